@@ -0,0 +1,38 @@
+package websocket
+
+import "bytes"
+
+// BufferPool provides the buffers that messages read by Next are stored in,
+// e.g. a *sync.Pool shared by many connections.
+// Get may return nil, in which case a new buffer is allocated.
+//
+// Buffers are taken from the pool when a message arrives
+// and put back once the next one is requested, so idle connections don't hold any.
+type BufferPool interface {
+	Get() interface{}
+	Put(interface{})
+}
+
+// getBuffer returns the buffer the next message is stored in.
+func (ws *WebSocket) getBuffer() *bytes.Buffer {
+	if ws.pool == nil {
+		return new(bytes.Buffer)
+	}
+	buf, _ := ws.pool.Get().(*bytes.Buffer)
+	if buf == nil {
+		buf = new(bytes.Buffer)
+	}
+	buf.Reset()
+	ws.buf = buf
+	return buf
+}
+
+// releaseBuffer returns the buffer holding the current payload to the pool.
+func (ws *WebSocket) releaseBuffer() {
+	if ws.buf == nil {
+		return
+	}
+	ws.pool.Put(ws.buf)
+	ws.buf = nil
+	ws.payload = nil
+}
@@ -0,0 +1,52 @@
+package websocket_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+func TestBufferPool(t *testing.T) {
+	var pool sync.Pool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := Upgrader{BufferPool: &pool}
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for ws.Next() {
+			payload, opcode := ws.Message()
+			ws.WriteMessage(opcode, payload)
+		}
+	}))
+	defer srv.Close()
+
+	d := Dialer{BufferPool: &pool}
+	ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	for _, size := range []int{8192, 16, 1024} {
+		msg := bytes.Repeat([]byte{'a' + byte(size%26)}, size)
+		if err = ws.WriteMessage(OpcodeBinary, msg); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := true, ws.Next(); want != got {
+			t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+		}
+		payload, _ := ws.Message()
+		if want, got := msg, payload; !bytes.Equal(want, got) {
+			t.Errorf("want %d bytes, got %d bytes", len(want), len(got))
+		}
+	}
+}
@@ -2,9 +2,9 @@ package websocket
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -17,22 +17,78 @@ import (
 	"github.com/gbrlsnchs/websocket/internal"
 )
 
+// Dialer configures how connections to WebSocket servers are opened.
+// The zero value is ready to use.
+type Dialer struct {
+	// Timeout limits how long dialing the TCP connection may take.
+	Timeout time.Duration
+	// NetDialContext dials the TCP connection.
+	// If nil, a net.Dialer using Timeout is used.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// TLSConfig is used for "wss" addresses.
+	TLSConfig *tls.Config
+	// Header is sent along with the opening handshake request,
+	// e.g. for setting Authorization, Origin or User-Agent.
+	// Headers used by the handshake itself are overwritten.
+	Header http.Header
+	// Host overrides the Host header, which defaults to the address' host.
+	Host string
+	// Jar adds cookies to the request and stores the ones set by the server.
+	Jar http.CookieJar
+	// ReadBufferSize and WriteBufferSize set the size of I/O buffers.
+	// Zero means 4096 bytes.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// Compression offers permessage-deflate to the server.
+	Compression *CompressionOptions
+	// Subprotocols lists the application subprotocols offered to the server.
+	Subprotocols []string
+	// ReadLimits bounds the size of incoming frames and messages.
+	ReadLimits ReadLimits
+	// KeepAlive sends pings periodically to detect dead connections.
+	KeepAlive KeepAlive
+	// CloseTimeout limits how long Close waits for the peer's close frame.
+	// Zero means 5 seconds.
+	CloseTimeout time.Duration
+	// BufferPool provides the buffers that messages read by Next are stored in.
+	// If nil, every message is read into a new buffer.
+	BufferPool BufferPool
+}
+
 // Open creates a WebSocket instance in client mode.
 //
 // The address must use either "ws" or "wss" protocols.
 // If the port is omitted, it assumes port 80 for "ws" and port 443 for "wss".
 func Open(address string, timeout time.Duration) (*WebSocket, error) {
-	return open(address, timeout, nil)
+	d := Dialer{Timeout: timeout}
+	return d.Dial(address)
+}
+
+// OpenContext creates a WebSocket instance in client mode.
+//
+// The context covers dialing, the TLS handshake and the opening handshake.
+// If it's done before the connection is established, the socket is closed and ctx.Err is returned.
+func OpenContext(ctx context.Context, address string) (*WebSocket, error) {
+	var d Dialer
+	return d.DialContext(ctx, address)
 }
 
 // OpenTLS creates a secure WebSocket instance in client mode.
 //
 // If the URI scheme is "ws", the TLS configuration is ignored.
 func OpenTLS(address string, timeout time.Duration, config *tls.Config) (*WebSocket, error) {
-	return open(address, timeout, config)
+	d := Dialer{Timeout: timeout, TLSConfig: config}
+	return d.Dial(address)
+}
+
+// Dial creates a WebSocket instance in client mode using the dialer's configuration.
+func (d *Dialer) Dial(address string) (*WebSocket, error) {
+	return d.DialContext(context.Background(), address)
 }
 
-func open(address string, timeout time.Duration, config *tls.Config) (*WebSocket, error) {
+// DialContext is like Dial but the context covers dialing,
+// the TLS handshake and the opening handshake.
+func (d *Dialer) DialContext(ctx context.Context, address string) (*WebSocket, error) {
 	uri, err := url.Parse(address)
 	if err != nil {
 		return nil, err
@@ -55,10 +111,28 @@ func open(address string, timeout time.Duration, config *tls.Config) (*WebSocket
 		return nil, fmt.Errorf("websocket: unsupported protocol %s", uri.Scheme)
 	}
 
+	// Credentials from the address are sent using basic authentication.
+	user := uri.User
+	uri.User = nil
 	r, err := http.NewRequest(http.MethodGet, uri.String(), nil)
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range d.Header {
+		r.Header[k] = v
+	}
+	if d.Host != "" {
+		r.Host = d.Host
+	}
+	if user != nil && r.Header.Get("Authorization") == "" {
+		password, _ := user.Password()
+		r.SetBasicAuth(user.Username(), password)
+	}
+	if d.Jar != nil {
+		for _, c := range d.Jar.Cookies(uri) {
+			r.AddCookie(c)
+		}
+	}
 	r.Header.Set("Upgrade", internal.UpgradeHeader)
 	r.Header.Set("Connection", internal.ConnectionHeader)
 	r.Header.Set("Sec-WebSocket-Version", internal.SecWebSocketVersionHeader)
@@ -68,45 +142,139 @@ func open(address string, timeout time.Duration, config *tls.Config) (*WebSocket
 	}
 	encKey := base64.StdEncoding.EncodeToString(guid[:])
 	r.Header.Set("Sec-WebSocket-Key", encKey)
+	if d.Compression != nil {
+		r.Header.Set("Sec-WebSocket-Extensions", d.Compression.offer().String())
+	}
+	if len(d.Subprotocols) > 0 {
+		r.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
 
-	d := &net.Dialer{Timeout: timeout}
-	var conn net.Conn
-
+	dial := d.NetDialContext
+	if dial == nil {
+		nd := &net.Dialer{Timeout: d.Timeout}
+		dial = nd.DialContext
+	}
+	conn, err := dial(ctx, "tcp", uri.Host)
+	if err != nil {
+		return nil, err
+	}
+	stop := interruptOnDone(ctx, conn)
 	if isWSS {
-		conn, err = tls.DialWithDialer(d, "tcp", uri.Host, config)
-	} else {
-		conn, err = d.Dial("tcp", uri.Host)
+		conn, err = tlsHandshake(conn, uri.Hostname(), d.TLSConfig)
+	}
+	var (
+		rr *http.Response
+		rd *bufio.Reader
+	)
+	if err == nil {
+		// The reader is kept by the WebSocket, since frames may arrive along with the response.
+		rd = bufio.NewReaderSize(conn, bufferSize(d.ReadBufferSize))
+		rr, err = sendReq(r, conn, rd, encKey, d.Subprotocols)
+	}
+	if cerr := stop(); cerr != nil {
+		err = cerr
 	}
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	if err = sendReq(r, conn, encKey); err != nil {
+	dp, err := d.negotiateCompression(rr.Header)
+	if err != nil {
 		conn.Close()
 		return nil, err
 	}
-	return newWS(conn, true), nil
+	if d.Jar != nil {
+		if cookies := rr.Cookies(); len(cookies) > 0 {
+			d.Jar.SetCookies(uri, cookies)
+		}
+	}
+	ws := newWS(conn, true, rd, newWriter(conn, nil, d.WriteBufferSize))
+	ws.SetReadLimits(d.ReadLimits)
+	ws.SetKeepAlive(d.KeepAlive)
+	ws.closeTimeout = d.CloseTimeout
+	ws.pool = d.BufferPool
+	ws.subprotocol = rr.Header.Get("Sec-WebSocket-Protocol")
+	ws.header = rr.Header
+	if dp != nil {
+		ws.enableCompression(dp, d.Compression)
+	}
+	return ws, nil
+}
+
+// negotiateCompression validates the extensions accepted by the server.
+func (d *Dialer) negotiateCompression(hdr http.Header) (*deflateParams, error) {
+	exts, err := internal.ParseExtensions(hdr)
+	if err != nil {
+		return nil, err
+	}
+	if d.Compression == nil {
+		if len(exts) > 0 {
+			return nil, errInvalidExtensions
+		}
+		return nil, nil
+	}
+	return d.Compression.parseResponse(exts)
+}
+
+// interruptOnDone makes blocking operations on conn fail once ctx is done.
+// The returned function stops watching ctx, returning its error if it's done.
+func interruptOnDone(ctx context.Context, conn net.Conn) func() error {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0)) // unblock reads and writes right away
+		case <-stop:
+		}
+	}()
+	return func() error {
+		close(stop)
+		<-done
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return conn.SetDeadline(time.Time{})
+	}
+}
+
+func tlsHandshake(conn net.Conn, hostname string, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = hostname
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return conn, err
+	}
+	return tlsConn, nil
 }
 
-func sendReq(r *http.Request, conn net.Conn, encKey string) error {
+func sendReq(r *http.Request, conn net.Conn, rd *bufio.Reader, encKey string, protocols []string) (*http.Response, error) {
 	b, err := httputil.DumpRequestOut(r, true)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if _, err = conn.Write(b); err != nil {
-		return err
+		return nil, err
 	}
-	rd := bufio.NewReader(conn)
 	rr, err := http.ReadResponse(rd, r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if rr.StatusCode != http.StatusSwitchingProtocols {
-		return errors.New("websocket: client did not receive 101 status response")
+		return nil, newHandshakeError(rr)
 	}
-	return validateServerHeaders(rr.Header, encKey)
+	return rr, validateServerHeaders(rr.Header, encKey, protocols)
 }
 
-func validateServerHeaders(hdr http.Header, encKey string) error {
+func validateServerHeaders(hdr http.Header, encKey string, protocols []string) error {
 	switch {
 	case strings.ToLower(hdr.Get("Upgrade")) != internal.UpgradeHeader:
 		return internal.ErrUpgradeMismatch
@@ -125,5 +293,21 @@ func validateServerHeaders(hdr http.Header, encKey string) error {
 	if string(key) != string(dec) {
 		return internal.ErrSecWebSocketKeyMismatch
 	}
-	return nil
+	return validateSubprotocol(hdr, protocols)
+}
+
+func validateSubprotocol(hdr http.Header, protocols []string) error {
+	selected := internal.Subprotocols(hdr)
+	switch {
+	case len(selected) == 0:
+		return nil
+	case len(selected) > 1:
+		return internal.ErrSubprotocolMismatch
+	}
+	for _, p := range protocols {
+		if p == selected[0] {
+			return nil
+		}
+	}
+	return internal.ErrSubprotocolMismatch
 }
@@ -0,0 +1,272 @@
+package websocket_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+	"github.com/gbrlsnchs/websocket/internal"
+)
+
+func TestOpenContext(t *testing.T) {
+	// The server accepts connections but never answers the opening handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	testCases := []struct {
+		ctx func() (context.Context, context.CancelFunc)
+		err error
+	}{
+		{
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			err: context.DeadlineExceeded,
+		},
+		{
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			err: context.Canceled,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+			ws, err := OpenContext(ctx, "ws://"+ln.Addr().String())
+			if want, got := tc.err, err; want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+			if want, got := (*WebSocket)(nil), ws; want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestDialer(t *testing.T) {
+	reqs := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reqs <- req
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "qux"})
+		ws, err := UpgradeHTTP(w, req)
+		if err != nil {
+			return
+		}
+		ws.Close()
+	}))
+	defer srv.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := strings.Replace(srv.URL, "http://", "ws://foo:bar@", 1)
+	u, _ := url.Parse(srv.URL)
+	jar.SetCookies(u, []*http.Cookie{{Name: "theme", Value: "dark"}})
+	var dialed bool
+	d := Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = true
+			var nd net.Dialer
+			return nd.DialContext(ctx, network, addr)
+		},
+		Header: http.Header{
+			"Origin":     {"http://example.com"},
+			"User-Agent": {"test"},
+		},
+		Host: "example.com",
+		Jar:  jar,
+	}
+	ws, err := d.Dial(addr)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+
+	r := <-reqs
+	user, password, _ := r.BasicAuth()
+	cookie, _ := r.Cookie("theme")
+	testCases := []struct {
+		want, got interface{}
+	}{
+		{true, dialed},
+		{"foo", user},
+		{"bar", password},
+		{"http://example.com", r.Header.Get("Origin")},
+		{"test", r.Header.Get("User-Agent")},
+		{"example.com", r.Host},
+		{"dark", cookie.Value},
+		{2, len(jar.Cookies(u))}, // the session cookie was stored
+	}
+	for _, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("want %v, got %v", tc.want, tc.got)
+		}
+	}
+}
+
+func TestSubprotocols(t *testing.T) {
+	protocols := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := Upgrader{Subprotocols: []string{"v1"}}
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			close(protocols)
+			return
+		}
+		protocols <- ws.Subprotocol()
+		ws.Close()
+	}))
+	defer srv.Close()
+
+	d := Dialer{Timeout: time.Second, Subprotocols: []string{"v2", "v1"}}
+	ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+	if want, got := "v1", ws.Subprotocol(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := "v1", <-protocols; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestSubprotocolMismatch(t *testing.T) {
+	// The server selects a subprotocol regardless of the client's offer.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := http.Header{"Sec-Websocket-Protocol": {"other"}}
+		conn, _, err := internal.Handshake(w, r, hdr, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	for _, protocols := range [][]string{{"v1"}, nil} {
+		t.Run(fmt.Sprint(protocols), func(t *testing.T) {
+			d := Dialer{Timeout: time.Second, Subprotocols: protocols}
+			_, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+			if want, got := true, errors.Is(err, internal.ErrSubprotocolMismatch); want != got {
+				t.Errorf("want %t, got %t (%v)", want, got, err)
+			}
+		})
+	}
+}
+
+func TestDialerBufferedFrames(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		key, _ := internal.ConcatKey(r.Header.Get("Sec-WebSocket-Key"))
+		// The first frame is sent in the same packet as the response.
+		res := "HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(key) + "\r\n\r\n"
+		conn.Write(append([]byte(res), 0x81, 0x05, 'h', 'e', 'l', 'l', 'o'))
+	}()
+
+	ws, err := Open("ws://"+ln.Addr().String(), time.Second)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+	if want, got := true, ws.Next(); want != got {
+		t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+	}
+	payload, _ := ws.Message()
+	if want, got := "hello", string(payload); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestHandshakeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ok" {
+			u := Upgrader{Header: http.Header{"X-Server": {"test"}}}
+			ws, err := u.Upgrade(w, r)
+			if err != nil {
+				return
+			}
+			ws.Close()
+			return
+		}
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(strings.Repeat("a", 10000)))
+	}))
+	defer srv.Close()
+	addr := strings.Replace(srv.URL, "http", "ws", 1)
+
+	ws, err := Open(addr, time.Second)
+	var herr *HandshakeError
+	if want, got := true, errors.As(err, &herr); want != got {
+		t.Fatalf("want %t, got %t (%v)", want, got, err)
+	}
+	if want, got := (*WebSocket)(nil), ws; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	testCases := []struct {
+		want, got interface{}
+	}{
+		{http.StatusServiceUnavailable, herr.Response.StatusCode},
+		{"120", herr.Response.Header.Get("Retry-After")},
+		{strings.Repeat("a", 4096), string(herr.Body)},
+	}
+	for _, tc := range testCases {
+		if tc.want != tc.got {
+			t.Errorf("want %v, got %v", tc.want, tc.got)
+		}
+	}
+
+	ws, err = Open(addr+"/ok", time.Second)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+	if want, got := "test", ws.Header().Get("X-Server"); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
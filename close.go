@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultCloseTimeout is how long Close waits for the peer's close frame by default.
+const defaultCloseTimeout = 5 * time.Second
+
+var errInvalidCloseReason = errors.New("websocket: close reason longer than 123 bytes or not valid UTF-8")
+
+// CloseError is reported by Err when the peer closes the connection.
+// Connections dropped without a close frame are reported with code 1006.
+type CloseError struct {
+	// Code is the close code sent by the peer, or 1005 if it sent none.
+	Code uint16
+	// Reason is the text sent along with the close code.
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with code %d: %s", e.Code, e.Reason)
+}
+
+// Close closes the connection by sending the close code set by SetCloseCode,
+// or 1000 if none was set. See CloseWithReason.
+func (ws *WebSocket) Close() error {
+	ws.mu.Lock()
+	cc := ws.cc
+	ws.mu.Unlock()
+	if cc == 0 {
+		cc = 1000
+	}
+	return ws.CloseWithReason(cc, "")
+}
+
+// CloseWithReason starts the closing handshake by sending a close frame with cc and reason,
+// then waits for the peer's close frame before dropping the connection.
+// The wait is bounded by the close timeout, 5 seconds by default.
+// When called while an EventLoop worker is reading frames, such as from its Handler,
+// it returns once the close frame is sent and the event loop completes the handshake.
+//
+// If no goroutine is reading messages, frames received until then are discarded.
+// Calling it after the connection is closed is a no-op.
+func (ws *WebSocket) CloseWithReason(cc uint16, reason string) error {
+	if !validCloseCode(cc) {
+		return ErrInvalidCloseCode
+	}
+	if len(reason) > 123 || !utf8.ValidString(reason) {
+		return errInvalidCloseReason
+	}
+	ws.mu.Lock()
+	if ws.state != stateOpen {
+		ws.mu.Unlock()
+		return nil
+	}
+	ws.state = stateClosing
+	ws.cc = cc
+	serving := ws.serving
+	ws.mu.Unlock()
+
+	err := ws.writeClose(cc, reason)
+	if err == nil && serving {
+		// Waiting would block the worker that reads the peer's close frame.
+		time.AfterFunc(ws.waitTimeout(), ws.drop)
+		return nil
+	}
+	if err == nil {
+		ws.waitClose()
+	}
+	ws.mu.Lock()
+	ws.setClosed()
+	ws.mu.Unlock()
+	if cerr := ws.conn.Close(); err == nil && !errors.Is(cerr, net.ErrClosed) {
+		err = cerr
+	}
+	return err
+}
+
+// waitClose waits for the peer's close frame, reading it if no goroutine is doing so.
+func (ws *WebSocket) waitClose() {
+	timeout := ws.waitTimeout()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	ws.mu.Lock()
+	polled := ws.polled
+	ws.mu.Unlock()
+	lock := ws.readLock
+	if polled {
+		lock = nil // the event loop reads the close frame
+	}
+	select {
+	case lock <- struct{}{}:
+		defer ws.unlockRead()
+		ws.conn.SetReadDeadline(time.Now().Add(timeout))
+		ws.discardUntilClose()
+	case <-ws.done: // the close frame was received by a reader
+	case <-t.C:
+	}
+}
+
+// waitTimeout returns how long to wait for the peer's close frame.
+func (ws *WebSocket) waitTimeout() time.Duration {
+	if ws.closeTimeout <= 0 {
+		return defaultCloseTimeout
+	}
+	return ws.closeTimeout
+}
+
+// discardUntilClose discards frames until the peer's close frame arrives.
+// The caller must hold the read lock.
+func (ws *WebSocket) discardUntilClose() {
+	fb := ws.fb
+	if fb.remaining > 0 || !fb.first {
+		// Readers of the current message can't get the rest of it anymore.
+		fb.err = io.ErrUnexpectedEOF
+	}
+	for {
+		if err := fb.discard(); err != nil {
+			return
+		}
+		h, err := fb.readHeader()
+		if err != nil {
+			return
+		}
+		if h.opcode < opcodeClose {
+			continue
+		}
+		f, err := fb.readFrame(h)
+		if err != nil {
+			return
+		}
+		if f.opcode == opcodeClose {
+			ws.handleClose(f)
+			return
+		}
+	}
+}
+
+// handleClose answers a close frame unless one was already sent,
+// then drops the connection and returns the close error.
+func (ws *WebSocket) handleClose(f frame) error {
+	switch {
+	case f.hasCloseCode && !validCloseCode(f.cc):
+		return ws.readError(ErrInvalidCloseCode)
+	case !utf8.Valid(f.payload):
+		return ws.readError(ErrInvalidUTF8)
+	}
+	cerr := &CloseError{Code: 1005, Reason: string(f.payload)}
+	if f.hasCloseCode {
+		cerr.Code = f.cc
+	}
+	ws.mu.Lock()
+	echo := ws.state == stateOpen
+	ws.cc = cerr.Code
+	if ws.err == nil {
+		ws.err = cerr
+	}
+	ws.setClosed()
+	ws.mu.Unlock()
+
+	if echo {
+		if f.hasCloseCode {
+			ws.writeClose(f.cc, "")
+		} else {
+			ws.writeControl(opcodeClose, nil)
+		}
+	}
+	ws.conn.Close()
+	return cerr
+}
+
+func (ws *WebSocket) writeClose(cc uint16, reason string) error {
+	var b [125]byte
+	binary.BigEndian.PutUint16(b[:], cc)
+	n := 2 + copy(b[2:], reason)
+	return ws.writeControl(opcodeClose, b[:n])
+}
+
+func (ws *WebSocket) lockRead()   { ws.readLock <- struct{}{} }
+func (ws *WebSocket) unlockRead() { <-ws.readLock }
@@ -0,0 +1,112 @@
+package websocket_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+func TestClose(t *testing.T) {
+	t.Run("handshake", func(t *testing.T) {
+		errc := make(chan error, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws, err := UpgradeHTTP(w, r)
+			if err != nil {
+				errc <- err
+				return
+			}
+			for ws.Next() {
+			}
+			errc <- ws.Err()
+		}))
+		defer srv.Close()
+
+		d := Dialer{CloseTimeout: time.Minute}
+		ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+		if want, got := (error)(nil), err; want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		start := time.Now()
+		if want, got := (error)(nil), ws.CloseWithReason(4000, "bye"); want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		// The server's echo ends the handshake before the timeout.
+		if want, got := true, time.Since(start) < time.Minute; want != got {
+			t.Errorf("want %t, got %t", want, got)
+		}
+		if want, got := (error)(&CloseError{Code: 4000, Reason: "bye"}), <-errc; !reflect.DeepEqual(want, got) {
+			t.Errorf("want %v, got %v", want, got)
+		}
+		if want, got := (error)(&CloseError{Code: 4000}), ws.Err(); !reflect.DeepEqual(want, got) {
+			t.Errorf("want %v, got %v", want, got)
+		}
+		if want, got := false, ws.Next(); want != got {
+			t.Errorf("want %t, got %t", want, got)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws, err := UpgradeHTTP(w, r)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			<-done // never reads the close frame
+		}))
+		defer srv.Close()
+		defer close(done)
+
+		d := Dialer{CloseTimeout: 50 * time.Millisecond}
+		ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+		if want, got := (error)(nil), err; want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		start := time.Now()
+		ws.Close()
+		if want, got := true, time.Since(start) >= 50*time.Millisecond; want != got {
+			t.Errorf("want %t, got %t", want, got)
+		}
+		if want, got := uint16(1000), ws.CloseCode(); want != got {
+			t.Errorf("want %d, got %d", want, got)
+		}
+	})
+	t.Run("abnormal", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws, err := UpgradeHTTP(w, r)
+			if err != nil {
+				return
+			}
+			// Timing out drops the connection without a close frame.
+			ws.SetReadDeadline(time.Now())
+			ws.Next()
+		}))
+		defer srv.Close()
+
+		ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+		if want, got := (error)(nil), err; want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		defer ws.Close()
+		if want, got := false, ws.Next(); want != got {
+			t.Fatalf("want %t, got %t", want, got)
+		}
+		if want, got := (error)(&CloseError{Code: 1006}), ws.Err(); !reflect.DeepEqual(want, got) {
+			t.Errorf("want %v, got %v", want, got)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		var ws WebSocket
+		if want, got := true, ws.CloseWithReason(1005, "") != nil; want != got {
+			t.Errorf("want %t, got %t", want, got)
+		}
+		if want, got := true, ws.CloseWithReason(1000, strings.Repeat("a", 124)) != nil; want != got {
+			t.Errorf("want %t, got %t", want, got)
+		}
+	})
+}
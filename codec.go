@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Codec encodes values into messages and decodes messages into values.
+type Codec interface {
+	// Opcode is the opcode of the messages written with Encode,
+	// either OpcodeText or OpcodeBinary.
+	Opcode() uint8
+	// Encode writes v as the payload of a message.
+	Encode(w io.Writer, v interface{}) error
+	// Decode reads the payload of a message into v.
+	Decode(r io.Reader, v interface{}) error
+}
+
+// JSON is a Codec for JSON text messages using package encoding/json.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Opcode() uint8 { return OpcodeText }
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error { return json.NewEncoder(w).Encode(v) }
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error { return json.NewDecoder(r).Decode(v) }
+
+// ReadValue reads the next message and decodes it into v using c.
+func (ws *WebSocket) ReadValue(c Codec, v interface{}) error {
+	_, rd, err := ws.NextReader()
+	if err != nil {
+		return err
+	}
+	return c.Decode(rd, v)
+}
+
+// WriteValue encodes v using c and sends it as a single message.
+// Nothing is sent if encoding fails.
+//
+// It's safe to call WriteValue from many goroutines at once.
+func (ws *WebSocket) WriteValue(c Codec, v interface{}) error {
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, v); err != nil {
+		return err
+	}
+	return ws.WriteMessage(c.Opcode(), buf.Bytes())
+}
+
+// ReadJSON reads the next message and decodes it from JSON into v.
+func (ws *WebSocket) ReadJSON(v interface{}) error { return ws.ReadValue(JSON, v) }
+
+// WriteJSON sends v encoded as JSON in a text message.
+func (ws *WebSocket) WriteJSON(v interface{}) error { return ws.WriteValue(JSON, v) }
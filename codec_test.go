@@ -0,0 +1,84 @@
+package websocket_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+type point struct {
+	X, Y int
+}
+
+func TestCodec(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := UpgradeHTTP(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		conn := NewTypedConn[point](ws, nil)
+		for {
+			p, err := conn.Receive()
+			if err != nil {
+				return
+			}
+			p.X, p.Y = p.Y, p.X
+			if err = conn.Send(p); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+
+	t.Run("ReadJSON", func(t *testing.T) {
+		if err := ws.WriteJSON(point{1, 2}); err != nil {
+			t.Fatal(err)
+		}
+		var p point
+		if want, got := (error)(nil), ws.ReadJSON(&p); want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		if want, got := (point{2, 1}), p; want != got {
+			t.Errorf("want %v, got %v", want, got)
+		}
+	})
+	t.Run("Read", func(t *testing.T) {
+		// Messages read by Next can be decoded through Read.
+		for i := 0; i < 2; i++ {
+			if err := ws.WriteJSON(point{i, 3}); err != nil {
+				t.Fatal(err)
+			}
+			if want, got := true, ws.Next(); want != got {
+				t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+			}
+			var p point
+			if want, got := (error)(nil), json.NewDecoder(ws).Decode(&p); want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			if want, got := (point{3, i}), p; want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+			if _, err := ws.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("want %v, got %v", io.EOF, err)
+			}
+		}
+	})
+	t.Run("encoding error", func(t *testing.T) {
+		if want, got := true, ws.WriteJSON(func() {}) != nil; want != got {
+			t.Errorf("want %t, got %t", want, got)
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/gbrlsnchs/websocket/internal"
+)
+
+const (
+	extPermessageDeflate = "permessage-deflate"
+
+	minWindowBits = 8
+	maxWindowBits = 15
+	windowSize    = 1 << maxWindowBits
+)
+
+var errInvalidExtensions = errors.New("websocket: server sent invalid Sec-WebSocket-Extensions")
+
+// deflateTail is what a sender strips from every compressed message
+// followed by an empty final block, so that the decompressor ends with io.EOF.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// flushMarker ends the output of every flush.
+var flushMarker = deflateTail[:4]
+
+// CompressionOptions enables and configures the permessage-deflate extension (RFC 7692).
+//
+// Zero values leave each parameter unrequested.
+type CompressionOptions struct {
+	// Level is the compress/flate level used when writing.
+	// Zero means flate.DefaultCompression.
+	Level int
+	// ServerNoContextTakeover makes the server reset its compression context for every message.
+	ServerNoContextTakeover bool
+	// ClientNoContextTakeover makes the client reset its compression context for every message.
+	ClientNoContextTakeover bool
+	// ServerMaxWindowBits limits the LZ77 window used by the server, from 8 to 15.
+	ServerMaxWindowBits int
+	// ClientMaxWindowBits limits the LZ77 window used by the client, from 8 to 15.
+	ClientMaxWindowBits int
+}
+
+// deflateParams are the permessage-deflate parameters agreed during the handshake.
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+	serverMaxWindowBits     int
+	clientMaxWindowBits     int
+}
+
+func (dp *deflateParams) extension() internal.Extension {
+	ext := internal.Extension{Name: extPermessageDeflate}
+	if dp.serverNoContextTakeover {
+		ext.Params = append(ext.Params, internal.Param{Name: "server_no_context_takeover"})
+	}
+	if dp.clientNoContextTakeover {
+		ext.Params = append(ext.Params, internal.Param{Name: "client_no_context_takeover"})
+	}
+	if dp.serverMaxWindowBits > 0 {
+		ext.Params = append(ext.Params, internal.Param{
+			Name:  "server_max_window_bits",
+			Value: strconv.Itoa(dp.serverMaxWindowBits),
+		})
+	}
+	if dp.clientMaxWindowBits > 0 && dp.clientMaxWindowBits < maxWindowBits {
+		ext.Params = append(ext.Params, internal.Param{
+			Name:  "client_max_window_bits",
+			Value: strconv.Itoa(dp.clientMaxWindowBits),
+		})
+	}
+	return ext
+}
+
+// accept returns the parameters of the first acceptable offer, or nil if there's none.
+func (opts *CompressionOptions) accept(offers []internal.Extension) *deflateParams {
+	for _, ext := range offers {
+		if ext.Name != extPermessageDeflate {
+			continue
+		}
+		dp, ok := parseDeflateParams(ext.Params, true)
+		if !ok {
+			continue
+		}
+		dp.serverNoContextTakeover = dp.serverNoContextTakeover || opts.ServerNoContextTakeover
+		dp.clientNoContextTakeover = dp.clientNoContextTakeover || opts.ClientNoContextTakeover
+		if dp.serverMaxWindowBits > 0 {
+			dp.serverMaxWindowBits = minBits(dp.serverMaxWindowBits, opts.ServerMaxWindowBits)
+		}
+		if dp.clientMaxWindowBits > 0 {
+			dp.clientMaxWindowBits = minBits(dp.clientMaxWindowBits, opts.ClientMaxWindowBits)
+		}
+		return dp
+	}
+	return nil
+}
+
+// offer returns the extension offered by a client.
+func (opts *CompressionOptions) offer() internal.Extension {
+	dp := &deflateParams{
+		serverNoContextTakeover: opts.ServerNoContextTakeover,
+		clientNoContextTakeover: opts.ClientNoContextTakeover,
+		serverMaxWindowBits:     opts.ServerMaxWindowBits,
+		clientMaxWindowBits:     opts.ClientMaxWindowBits,
+	}
+	ext := dp.extension()
+	if opts.ClientMaxWindowBits <= 0 || opts.ClientMaxWindowBits >= maxWindowBits {
+		// Let the server know it may limit the client's window.
+		ext.Params = append(ext.Params, internal.Param{Name: "client_max_window_bits"})
+	}
+	return ext
+}
+
+// parseResponse validates the extensions accepted by a server.
+func (opts *CompressionOptions) parseResponse(exts []internal.Extension) (*deflateParams, error) {
+	switch {
+	case len(exts) == 0:
+		return nil, nil
+	case len(exts) > 1 || exts[0].Name != extPermessageDeflate:
+		return nil, errInvalidExtensions
+	}
+	dp, ok := parseDeflateParams(exts[0].Params, false)
+	if !ok {
+		return nil, errInvalidExtensions
+	}
+	if opts.ServerMaxWindowBits > 0 && dp.serverMaxWindowBits > opts.ServerMaxWindowBits {
+		return nil, errInvalidExtensions
+	}
+	dp.clientNoContextTakeover = dp.clientNoContextTakeover || opts.ClientNoContextTakeover
+	dp.clientMaxWindowBits = minBits(dp.clientMaxWindowBits, opts.ClientMaxWindowBits)
+	return dp, nil
+}
+
+// parseDeflateParams validates permessage-deflate parameters.
+// Only offers may have client_max_window_bits without a value.
+func parseDeflateParams(params []internal.Param, offer bool) (*deflateParams, bool) {
+	var (
+		dp   deflateParams
+		seen = make(map[string]bool, len(params))
+	)
+	for _, p := range params {
+		if seen[p.Name] {
+			return nil, false
+		}
+		seen[p.Name] = true
+
+		switch p.Name {
+		case "server_no_context_takeover":
+			if p.Value != "" {
+				return nil, false
+			}
+			dp.serverNoContextTakeover = true
+		case "client_no_context_takeover":
+			if p.Value != "" {
+				return nil, false
+			}
+			dp.clientNoContextTakeover = true
+		case "server_max_window_bits":
+			bits, ok := parseWindowBits(p.Value)
+			if !ok {
+				return nil, false
+			}
+			dp.serverMaxWindowBits = bits
+		case "client_max_window_bits":
+			if p.Value == "" && offer {
+				dp.clientMaxWindowBits = maxWindowBits
+				continue
+			}
+			bits, ok := parseWindowBits(p.Value)
+			if !ok {
+				return nil, false
+			}
+			dp.clientMaxWindowBits = bits
+		default:
+			return nil, false
+		}
+	}
+	return &dp, true
+}
+
+func parseWindowBits(s string) (int, bool) {
+	if len(s) == 0 || s[0] == '0' {
+		return 0, false
+	}
+	bits, err := strconv.Atoi(s)
+	if err != nil || bits < minWindowBits || bits > maxWindowBits {
+		return 0, false
+	}
+	return bits, true
+}
+
+// minBits returns the smallest window bits, ignoring unset values.
+func minBits(a, b int) int {
+	if a <= 0 || b > 0 && b < a {
+		return b
+	}
+	return a
+}
+
+// deflater compresses outgoing messages.
+type deflater struct {
+	fw       *flate.Writer
+	buf      bytes.Buffer
+	level    int
+	takeover bool
+}
+
+func newDeflater(level, windowBits int, takeover bool) *deflater {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if windowBits > 0 && windowBits < maxWindowBits {
+		// Package flate always uses the full window,
+		// so don't emit back-references at all when a smaller one is required.
+		level = flate.HuffmanOnly
+	}
+	return &deflater{level: level, takeover: takeover}
+}
+
+// reset prepares the compressor for a new message,
+// discarding the previous context unless context takeover is in use.
+func (d *deflater) reset() error {
+	d.buf.Reset()
+	switch {
+	case d.fw == nil:
+		fw, err := flate.NewWriter(&d.buf, d.level)
+		if err != nil {
+			return err
+		}
+		d.fw = fw
+	case !d.takeover:
+		d.fw.Reset(&d.buf)
+	}
+	return nil
+}
+
+// inflater decompresses incoming messages.
+type inflater struct {
+	fr       io.ReadCloser
+	dict     []byte
+	takeover bool
+}
+
+// reader returns a reader that decompresses the message read from src.
+func (i *inflater) reader(src io.Reader) (io.Reader, error) {
+	src = io.MultiReader(src, bytes.NewReader(deflateTail))
+	dict := i.dict
+	if over := len(dict) - windowSize; over > 0 {
+		dict = dict[over:]
+	}
+	if i.fr == nil {
+		i.fr = flate.NewReaderDict(src, dict)
+	} else if err := i.fr.(flate.Resetter).Reset(src, dict); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Read reads decompressed data, keeping at least the last window of it if context takeover is in use.
+func (i *inflater) Read(b []byte) (int, error) {
+	n, err := i.fr.Read(b)
+	if i.takeover && n > 0 {
+		i.keep(b[:n])
+	}
+	return n, err
+}
+
+// keep appends p to the dictionary, which holds up to two windows
+// so that it's only compacted once in a while rather than on every read.
+func (i *inflater) keep(p []byte) {
+	if len(p) >= windowSize {
+		i.dict = append(i.dict[:0], p[len(p)-windowSize:]...)
+		return
+	}
+	if len(i.dict)+len(p) > 2*windowSize {
+		i.dict = append(i.dict[:0], i.dict[len(i.dict)+len(p)-windowSize:]...)
+	}
+	i.dict = append(i.dict, p...)
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gbrlsnchs/websocket/internal"
+)
+
+func parseExtensions(t *testing.T, s string) []internal.Extension {
+	hdr := http.Header{}
+	if s != "" {
+		hdr.Set("Sec-WebSocket-Extensions", s)
+	}
+	exts, err := internal.ParseExtensions(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exts
+}
+
+func TestAcceptDeflate(t *testing.T) {
+	testCases := []struct {
+		opts  CompressionOptions
+		offer string
+		want  string // empty if rejected
+	}{
+		{offer: "permessage-deflate", want: "permessage-deflate"},
+		{offer: "permessage-deflate; client_max_window_bits", want: "permessage-deflate"},
+		{offer: "permessage-deflate; client_no_context_takeover", want: "permessage-deflate; client_no_context_takeover"},
+		{offer: `permessage-deflate; server_max_window_bits="10"`, want: "permessage-deflate; server_max_window_bits=10"},
+		{
+			opts:  CompressionOptions{ClientMaxWindowBits: 10},
+			offer: "permessage-deflate; client_max_window_bits",
+			want:  "permessage-deflate; client_max_window_bits=10",
+		},
+		{
+			// The client can't limit its window, so the server doesn't ask it to.
+			opts:  CompressionOptions{ClientMaxWindowBits: 10},
+			offer: "permessage-deflate",
+			want:  "permessage-deflate",
+		},
+		{
+			opts:  CompressionOptions{ServerMaxWindowBits: 9},
+			offer: "permessage-deflate; server_max_window_bits=12",
+			want:  "permessage-deflate; server_max_window_bits=9",
+		},
+		{
+			opts:  CompressionOptions{ServerNoContextTakeover: true},
+			offer: "permessage-deflate",
+			want:  "permessage-deflate; server_no_context_takeover",
+		},
+		// Unacceptable offers are skipped.
+		{offer: "x-webkit-deflate-frame, permessage-deflate", want: "permessage-deflate"},
+		{offer: "permessage-deflate; foo, permessage-deflate; client_max_window_bits", want: "permessage-deflate"},
+		{offer: "x-webkit-deflate-frame", want: ""},
+		{offer: "permessage-deflate; foo", want: ""},
+		{offer: "permessage-deflate; server_max_window_bits", want: ""},
+		{offer: "permessage-deflate; server_max_window_bits=7", want: ""},
+		{offer: "permessage-deflate; server_max_window_bits=16", want: ""},
+		{offer: "permessage-deflate; server_max_window_bits=010", want: ""},
+		{offer: "permessage-deflate; client_max_window_bits=x", want: ""},
+		{offer: "permessage-deflate; server_no_context_takeover=1", want: ""},
+		{offer: "permessage-deflate; server_no_context_takeover; server_no_context_takeover", want: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.offer, func(t *testing.T) {
+			var got string
+			if dp := tc.opts.accept(parseExtensions(t, tc.offer)); dp != nil {
+				got = dp.extension().String()
+			}
+			if want := tc.want; want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestParseDeflateResponse(t *testing.T) {
+	testCases := []struct {
+		opts     CompressionOptions
+		response string
+		want     string
+		err      error
+	}{
+		{response: "", want: "", err: nil},
+		{response: "permessage-deflate", want: "permessage-deflate", err: nil},
+		{
+			response: "permessage-deflate; server_no_context_takeover; client_max_window_bits=10",
+			want:     "permessage-deflate; server_no_context_takeover; client_max_window_bits=10",
+			err:      nil,
+		},
+		{
+			opts:     CompressionOptions{ClientNoContextTakeover: true, ClientMaxWindowBits: 9},
+			response: "permessage-deflate",
+			want:     "permessage-deflate; client_no_context_takeover; client_max_window_bits=9",
+			err:      nil,
+		},
+		{
+			opts:     CompressionOptions{ServerMaxWindowBits: 10},
+			response: "permessage-deflate; server_max_window_bits=9",
+			want:     "permessage-deflate; server_max_window_bits=9",
+			err:      nil,
+		},
+		{
+			opts:     CompressionOptions{ServerMaxWindowBits: 10},
+			response: "permessage-deflate; server_max_window_bits=12",
+			err:      errInvalidExtensions,
+		},
+		{response: "permessage-deflate; client_max_window_bits", err: errInvalidExtensions},
+		{response: "permessage-deflate; client_max_window_bits=20", err: errInvalidExtensions},
+		{response: "permessage-deflate; foo", err: errInvalidExtensions},
+		{response: "permessage-deflate; client_no_context_takeover=true", err: errInvalidExtensions},
+		{response: "permessage-deflate; client_no_context_takeover; client_no_context_takeover", err: errInvalidExtensions},
+		{response: "permessage-deflate, permessage-deflate", err: errInvalidExtensions},
+		{response: "x-webkit-deflate-frame", err: errInvalidExtensions},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.response, func(t *testing.T) {
+			dp, err := tc.opts.parseResponse(parseExtensions(t, tc.response))
+			if want, got := tc.err, err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			var got string
+			if dp != nil {
+				got = dp.extension().String()
+			}
+			if want := tc.want; want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package websocket_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+// recordConn records what is read from and written to a connection.
+type recordConn struct {
+	net.Conn
+	rd, wr bytes.Buffer
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.rd.Write(b[:n])
+	return n, err
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.wr.Write(b)
+	return c.Conn.Write(b)
+}
+
+// firstFrame returns the first byte of the first frame following an HTTP message.
+func firstFrame(b []byte) byte {
+	i := bytes.Index(b, []byte("\r\n\r\n"))
+	if i < 0 || i+4 >= len(b) {
+		return 0
+	}
+	return b[i+4]
+}
+
+func TestCompression(t *testing.T) {
+	testCases := []struct {
+		server    *CompressionOptions
+		client    *CompressionOptions
+		extension string
+	}{
+		{server: nil, client: &CompressionOptions{}, extension: ""},
+		{server: &CompressionOptions{}, client: nil, extension: ""},
+		{server: &CompressionOptions{}, client: &CompressionOptions{}, extension: "permessage-deflate"},
+		{
+			server:    &CompressionOptions{ServerNoContextTakeover: true},
+			client:    &CompressionOptions{ClientNoContextTakeover: true},
+			extension: "permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+		},
+		{
+			server:    &CompressionOptions{ClientMaxWindowBits: 9},
+			client:    &CompressionOptions{ServerMaxWindowBits: 10},
+			extension: "permessage-deflate; server_max_window_bits=10; client_max_window_bits=9",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				u := Upgrader{Compression: tc.server}
+				ws, err := u.Upgrade(w, r)
+				if err != nil {
+					return
+				}
+				for ws.Next() {
+					payload, opcode := ws.Message()
+					ws.SetOpcode(opcode)
+					ws.Write(payload)
+				}
+			}))
+			defer srv.Close()
+
+			var conn *recordConn
+			d := Dialer{
+				Timeout:     time.Second,
+				Compression: tc.client,
+				NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					var nd net.Dialer
+					c, err := nd.DialContext(ctx, network, addr)
+					conn = &recordConn{Conn: c}
+					return conn, err
+				},
+			}
+			ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			defer ws.Close()
+			if want, got := tc.extension, ws.Header().Get("Sec-WebSocket-Extensions"); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+
+			for i := 0; i < 3; i++ {
+				msg := bytes.Repeat([]byte("hello, compression! "), 100*(i+1))
+				if _, err = ws.Write(append([]byte(nil), msg...)); err != nil {
+					t.Fatal(err)
+				}
+				if want, got := true, ws.Next(); want != got {
+					t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+				}
+				payload, _ := ws.Message()
+				if want, got := string(msg), string(payload); want != got {
+					t.Errorf("want %q, got %q", want, got)
+				}
+			}
+
+			// Messages are compressed in both directions only if the extension was negotiated.
+			compressed := tc.extension != ""
+			if want, got := compressed, firstFrame(conn.wr.Bytes())&0x40 != 0; want != got {
+				t.Errorf("want RSV1 %t, got %t", want, got)
+			}
+			if want, got := compressed, firstFrame(conn.rd.Bytes())&0x40 != 0; want != got {
+				t.Errorf("want RSV1 %t, got %t", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,438 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+var (
+	// ErrEventLoopUnsupported is returned by EventLoop.Add on platforms without a readiness API.
+	ErrEventLoopUnsupported = errors.New("websocket: event loop is not supported on this platform")
+	// ErrNotPollable is returned by EventLoop.Add for connections that don't expose a file descriptor,
+	// such as TLS connections.
+	ErrNotPollable = errors.New("websocket: connection can't be polled")
+	// ErrEventLoopClosed is returned by EventLoop.Add after the event loop is closed.
+	ErrEventLoopClosed = errors.New("websocket: event loop closed")
+)
+
+// loopReadSize is how much is read from a socket at once.
+const loopReadSize = 32 * 1024
+
+var loopBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getLoopBuffer() *bytes.Buffer {
+	buf := loopBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// EventLoop reads messages from many connections using a readiness API,
+// currently epoll on Linux, and a small pool of worker goroutines.
+// The zero value is ready to use once Handler is set.
+//
+// Instead of a goroutine blocked in Next, connections only hold memory for incoming data
+// while a frame or message is partially received, so idle connections are cheap.
+// Handler is called once a whole message has arrived, and control frames
+// are handled as usual. Pings that arrive between the fragments of a message
+// are answered once the message is complete.
+//
+// Connections added to an EventLoop must not be read with Next, NextReader or Read,
+// but writing to and closing them works as usual.
+type EventLoop struct {
+	// Workers is the number of goroutines reading frames and calling handlers.
+	// Zero means runtime.NumCPU.
+	Workers int
+	// Handler is called with every message received.
+	// The payload is only valid until it returns.
+	// Closing the connection from it doesn't wait for the peer's close frame,
+	// which is read by the event loop.
+	Handler func(ws *WebSocket, opcode uint8, payload []byte)
+	// OnClose is called once a connection is closed, with the error reported by WebSocket.Err.
+	OnClose func(ws *WebSocket, err error)
+
+	once   sync.Once
+	err    error
+	poller *poller
+	work   chan *loopConn
+	stop   chan struct{}
+
+	mu     sync.Mutex // guards conns, closed and the state of every loopConn
+	conns  map[int]*loopConn
+	closed bool
+}
+
+// loopConn is a connection served by an EventLoop.
+type loopConn struct {
+	ws      *WebSocket
+	fd      int
+	rc      syscall.RawConn
+	pending *bytes.Buffer // data not handled yet, nil while idle
+	src     bytes.Reader
+	eof     bool
+
+	busy   bool // whether a worker is serving the connection
+	closed bool
+}
+
+func (l *EventLoop) init() {
+	if l.poller, l.err = newPoller(); l.err != nil {
+		return
+	}
+	workers := l.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	l.conns = make(map[int]*loopConn)
+	l.work = make(chan *loopConn)
+	l.stop = make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go l.worker()
+	}
+	go l.poll()
+}
+
+// Add hands ws over to the event loop, which reads its messages from then on.
+// It returns ErrNotPollable if the underlying connection has no file descriptor.
+// If it fails, ws is left as it was and can still be read as usual.
+func (l *EventLoop) Add(ws *WebSocket) error {
+	if l.once.Do(l.init); l.err != nil {
+		return l.err
+	}
+	sc, ok := ws.conn.(syscall.Conn)
+	if !ok {
+		return ErrNotPollable
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	c := &loopConn{ws: ws, rc: rc}
+	if err = rc.Control(func(fd uintptr) { c.fd = int(fd) }); err != nil {
+		return err
+	}
+
+	// Register the connection before changing ws, so that it's left alone if the event loop is closed.
+	// It isn't served until it's polled.
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrEventLoopClosed
+	}
+	l.conns[c.fd] = c
+	c.busy = true
+	l.mu.Unlock()
+
+	ws.mu.Lock()
+	if ws.state == stateClosed {
+		ws.mu.Unlock()
+		l.mu.Lock()
+		if l.conns[c.fd] == c {
+			delete(l.conns, c.fd)
+		}
+		l.mu.Unlock()
+		return nil
+	}
+	ws.polled = true
+	ws.mu.Unlock()
+
+	// Keep whatever was read along with the handshake and replace the connection's read buffer,
+	// since frames are read from pending data from now on.
+	// The old buffer is only peeked at, so that it can be restored.
+	ws.lockRead()
+	rd := ws.fb.rd
+	if n := rd.Buffered(); n > 0 {
+		p, _ := rd.Peek(n)
+		c.pending = getLoopBuffer()
+		c.pending.Write(p)
+	}
+	ws.fb.rd = bufio.NewReaderSize(&c.src, 16)
+	ws.unlockRead()
+
+	l.mu.Lock()
+	if l.closed {
+		// The event loop was closed in the meantime, so give the connection back.
+		l.mu.Unlock()
+		ws.lockRead()
+		ws.fb.rd = rd
+		ws.unlockRead()
+		ws.mu.Lock()
+		ws.polled = false
+		ws.mu.Unlock()
+		l.release(c)
+		return ErrEventLoopClosed
+	}
+	c.busy = c.pending != nil
+	busy := c.busy
+	l.mu.Unlock()
+
+	ws.mu.Lock()
+	closed := ws.state == stateClosed
+	if !closed {
+		ws.onClosed = append(ws.onClosed, func() { l.remove(c) })
+	}
+	ws.mu.Unlock()
+	switch {
+	case closed:
+		l.remove(c)
+		return nil
+	case !busy:
+		return l.poller.add(c.fd)
+	}
+	// Serve the buffered frames first, the socket is registered once they're handled.
+	go func() {
+		select {
+		case l.work <- c:
+		case <-l.stop:
+		}
+	}()
+	return nil
+}
+
+// remove stops polling a closed connection.
+func (l *EventLoop) remove(c *loopConn) {
+	l.mu.Lock()
+	c.closed = true
+	if l.conns[c.fd] == c {
+		delete(l.conns, c.fd)
+		l.poller.remove(c.fd)
+	}
+	busy := c.busy
+	l.mu.Unlock()
+	// Connections being served are reported by their worker.
+	if !busy && l.OnClose != nil {
+		go func() { l.OnClose(c.ws, c.ws.Err()) }()
+	}
+}
+
+// Len returns the number of connections being served.
+func (l *EventLoop) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.conns)
+}
+
+// Close stops the event loop. Connections being served are left open.
+func (l *EventLoop) Close() error {
+	l.once.Do(l.init)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil || l.closed {
+		return l.err
+	}
+	l.closed = true
+	l.conns = nil
+	return l.poller.close()
+}
+
+func (l *EventLoop) poll() {
+	defer close(l.stop)
+	l.poller.wait(func(fd int) {
+		l.mu.Lock()
+		c := l.conns[fd]
+		if c != nil {
+			c.busy = true
+		}
+		l.mu.Unlock()
+		if c != nil {
+			l.work <- c
+		}
+	})
+}
+
+func (l *EventLoop) worker() {
+	buf := make([]byte, loopReadSize)
+	for {
+		select {
+		case c := <-l.work:
+			l.serve(c, buf)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// serve reads what's available from c and handles every complete message.
+// Pending data is processed after every read, so a frame over the read limits
+// fails before the rest of its payload is read.
+func (l *EventLoop) serve(c *loopConn, buf []byte) {
+	c.ws.setServing(true)
+	open := c.pending == nil || l.process(c)
+	for open && !c.eof {
+		n, err := c.read(buf)
+		if n > 0 {
+			if c.pending == nil {
+				c.pending = getLoopBuffer()
+			}
+			c.pending.Write(buf[:n])
+			open = l.process(c)
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if n <= 0 || err != nil {
+			c.eof = true
+		}
+	}
+	if c.eof {
+		c.ws.readError(io.EOF)
+	}
+	c.ws.setServing(false) // before the connection can be handed to another worker
+
+	l.mu.Lock()
+	c.busy = false
+	closed := c.closed
+	if !closed && !l.closed {
+		if err := l.poller.rearm(c.fd); err != nil {
+			l.mu.Unlock()
+			c.ws.readError(err)
+			return
+		}
+	}
+	l.mu.Unlock()
+	if closed {
+		l.release(c)
+		if l.OnClose != nil {
+			l.OnClose(c.ws, c.ws.Err())
+		}
+	}
+}
+
+// process handles every complete control frame or message in the pending data,
+// reporting whether the connection is still open.
+func (l *EventLoop) process(c *loopConn) bool {
+	ws, open := c.ws, true
+	for open {
+		data := c.pending.Bytes()
+		n, control := nextUnit(data, ws.fb.limits)
+		if n == 0 {
+			break
+		}
+		c.src.Reset(data[:n])
+		ws.fb.rd.Reset(&c.src)
+		open = l.handle(ws, control)
+		c.pending.Next(n)
+	}
+	if c.pending.Len() == 0 {
+		l.release(c)
+	}
+	return open
+}
+
+// handle reads a control frame or a whole message, reporting whether the connection is still open.
+func (l *EventLoop) handle(ws *WebSocket, control bool) bool {
+	if control {
+		ws.lockRead()
+		defer ws.unlockRead()
+		h, err := ws.fb.readHeader()
+		if err != nil {
+			return ws.readError(err) == nil
+		}
+		return ws.handleControl(h) == nil
+	}
+	opcode, rd, err := ws.NextReader()
+	if err != nil {
+		return false
+	}
+	msg := getLoopBuffer()
+	defer loopBuffers.Put(msg)
+	if _, err = msg.ReadFrom(rd); err != nil {
+		return false
+	}
+	// Skip what's left of the message within this unit.
+	if err = ws.discardMessage(); err != nil {
+		return false
+	}
+	if l.Handler != nil {
+		l.Handler(ws, opcode, msg.Bytes())
+	}
+	return true
+}
+
+// setServing records whether a worker is reading frames from ws.
+func (ws *WebSocket) setServing(serving bool) {
+	ws.mu.Lock()
+	ws.serving = serving
+	ws.mu.Unlock()
+}
+
+// release returns the pending buffer of an idle connection to the pool.
+func (l *EventLoop) release(c *loopConn) {
+	if c.pending != nil {
+		loopBuffers.Put(c.pending)
+		c.pending = nil
+	}
+}
+
+// nextUnit returns the length of the control frame or whole message at the start of p,
+// and whether it's a control frame, or 0 if more data is needed.
+// Frames that exceed the read limits or have an illegal length end a unit right after their header,
+// so that they fail as soon as the header arrives.
+func nextUnit(p []byte, lim ReadLimits) (n int, control bool) {
+	var (
+		msgSize   uint64
+		fragments int
+	)
+	for pos := 0; ; {
+		b0, size, hdrLen, ok := peekHeader(p[pos:])
+		if !ok {
+			return 0, false
+		}
+		control = b0&opcodeBits >= opcodeClose
+		if !control {
+			msgSize += size
+			fragments++
+		}
+		switch {
+		case size > math.MaxInt64,
+			lim.FrameSize > 0 && size > uint64(lim.FrameSize),
+			lim.MessageSize > 0 && msgSize > uint64(lim.MessageSize),
+			lim.Fragments > 0 && fragments > lim.Fragments,
+			control && size > 125:
+			return pos + hdrLen, control && pos == 0
+		case size > uint64(len(p)-pos-hdrLen):
+			return 0, false
+		}
+		end := pos + hdrLen + int(size)
+		switch {
+		case control && pos == 0: // not in the middle of a message
+			return end, true
+		case !control && b0&leftBit != 0:
+			return end, false
+		}
+		pos = end
+	}
+}
+
+// peekHeader decodes the frame header at the start of p without validating it.
+func peekHeader(p []byte) (b0 byte, size uint64, hdrLen int, ok bool) {
+	if len(p) < 2 {
+		return 0, 0, 0, false
+	}
+	b0, hdrLen = p[0], 2
+	if p[1]&leftBit != 0 {
+		hdrLen += len(mask{})
+	}
+	switch size = uint64(p[1] & lengthBits); size {
+	case 126:
+		if len(p) < 4 {
+			return 0, 0, 0, false
+		}
+		size, hdrLen = uint64(binary.BigEndian.Uint16(p[2:])), hdrLen+2
+	case 127:
+		if len(p) < 10 {
+			return 0, 0, 0, false
+		}
+		size, hdrLen = binary.BigEndian.Uint64(p[2:]), hdrLen+8
+	}
+	return b0, size, hdrLen, len(p) >= hdrLen
+}
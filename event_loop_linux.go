@@ -0,0 +1,94 @@
+//go:build linux
+
+package websocket
+
+import "syscall"
+
+// poller waits for sockets to become readable using epoll.
+// Sockets are registered as one-shot, so that only one worker serves a connection at a time.
+type poller struct {
+	fd     int
+	wake   [2]int // pipe used to interrupt wait
+	events [128]syscall.EpollEvent
+}
+
+func newPoller() (*poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &poller{fd: fd}
+	if err = syscall.Pipe2(p.wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(p.wake[0])}
+	if err = syscall.EpollCtl(fd, syscall.EPOLL_CTL_ADD, p.wake[0], &ev); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+const pollEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+func (p *poller) add(fd int) error {
+	ev := syscall.EpollEvent{Events: pollEvents, Fd: int32(fd)}
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, fd, &ev)
+}
+
+func (p *poller) rearm(fd int) error {
+	ev := syscall.EpollEvent{Events: pollEvents, Fd: int32(fd)}
+	err := syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, fd, &ev)
+	if err == syscall.ENOENT {
+		// Data that arrived with the handshake was served before the socket was registered.
+		return p.add(fd)
+	}
+	return err
+}
+
+func (p *poller) remove(fd int) error {
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait calls fn for every readable socket until the poller is closed.
+func (p *poller) wait(fn func(fd int)) error {
+	for {
+		n, err := syscall.EpollWait(p.fd, p.events[:], -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, ev := range p.events[:n] {
+			if int(ev.Fd) == p.wake[0] {
+				syscall.Close(p.wake[0])
+				syscall.Close(p.fd)
+				return nil
+			}
+			fn(int(ev.Fd))
+		}
+	}
+}
+
+func (p *poller) close() error {
+	_, err := syscall.Write(p.wake[1], []byte{0})
+	syscall.Close(p.wake[1])
+	return err
+}
+
+// read reads from the socket without waiting for data to arrive.
+func (c *loopConn) read(b []byte) (int, error) {
+	var (
+		n   int
+		err error
+	)
+	if rerr := c.rc.Read(func(fd uintptr) bool {
+		n, err = syscall.Read(int(fd), b)
+		return true
+	}); rerr != nil {
+		return n, rerr
+	}
+	return n, err
+}
@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// keepOpenConn keeps the socket open when the WebSocket is closed,
+// so that what the event loop left unread can be measured.
+type keepOpenConn struct{ *net.TCPConn }
+
+func (keepOpenConn) Close() error { return nil }
+
+func TestEventLoopBuffering(t *testing.T) {
+	// A frame announcing a huge payload.
+	huge := []byte{0x82, leftBit | 127, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4}
+	binary.BigEndian.PutUint64(huge[2:], 1<<40)
+	// A frame whose 64-bit length has its most significant bit set.
+	illegal := append([]byte(nil), huge...)
+	binary.BigEndian.PutUint64(illegal[2:], 1<<63)
+	// A message split in many one-byte fragments.
+	fragmented := clientFrame(0x02, []byte{'x'})
+	for i := 0; i < 5000; i++ {
+		fragmented = append(fragmented, clientFrame(0x00, []byte{'x'})...)
+	}
+
+	testCases := []struct {
+		name   string
+		limits ReadLimits
+		frames []byte
+		cc     uint16
+	}{
+		{name: "message size", limits: ReadLimits{MessageSize: 1024}, frames: huge, cc: 1009},
+		{name: "fragments", limits: ReadLimits{Fragments: 2}, frames: fragmented, cc: 1009},
+		{name: "illegal length", frames: illegal, cc: 1002},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// The frames are followed by plenty of data that should never be read.
+			data := append(tc.frames, bytes.Repeat([]byte{'x'}, 1<<20)...)
+			go func() {
+				client.Write(data)
+				client.(*net.TCPConn).CloseWrite()
+			}()
+			time.Sleep(100 * time.Millisecond) // let the socket fill up
+
+			closed := make(chan struct{})
+			l := EventLoop{OnClose: func(*WebSocket, error) { close(closed) }}
+			defer l.Close()
+			ws := newTestWS(keepOpenConn{conn.(*net.TCPConn)}, false)
+			ws.SetReadLimits(tc.limits)
+			if err = l.Add(ws); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-closed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("connection not closed")
+			}
+			if want, got := tc.cc, ws.CloseCode(); want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+
+			left, err := io.Copy(io.Discard, conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read := len(data) - int(left); read > loopReadSize {
+				t.Errorf("want at most %d bytes read, got %d", loopReadSize, read)
+			}
+		})
+	}
+}
+
+func TestEventLoopAddRace(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The frame was read along with the handshake.
+	client.Write(clientFrame(leftBit|OpcodeText, []byte("hello")))
+	rd := bufio.NewReader(conn)
+	if _, err = rd.Peek(1); err != nil {
+		t.Fatal(err)
+	}
+	ws := newWS(conn, false, rd, newWriter(conn, nil, 0))
+
+	// Close the event loop while Add waits for the read lock.
+	var l EventLoop
+	l.once.Do(l.init)
+	ws.lockRead()
+	errc := make(chan error, 1)
+	go func() { errc <- l.Add(ws) }()
+	for deadline := time.Now().Add(5 * time.Second); l.Len() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("connection not registered")
+		}
+	}
+	l.Close()
+	ws.unlockRead()
+	if want, got := ErrEventLoopClosed, <-errc; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	if !ws.Next() {
+		t.Fatal(ws.Err())
+	}
+	payload, _ := ws.Message()
+	if want, got := "hello", string(payload); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
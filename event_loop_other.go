@@ -0,0 +1,15 @@
+//go:build !linux
+
+package websocket
+
+type poller struct{}
+
+func newPoller() (*poller, error) { return nil, ErrEventLoopUnsupported }
+
+func (p *poller) add(fd int) error           { return ErrEventLoopUnsupported }
+func (p *poller) rearm(fd int) error         { return ErrEventLoopUnsupported }
+func (p *poller) remove(fd int) error        { return ErrEventLoopUnsupported }
+func (p *poller) wait(fn func(fd int)) error { return ErrEventLoopUnsupported }
+func (p *poller) close() error               { return ErrEventLoopUnsupported }
+
+func (c *loopConn) read(b []byte) (int, error) { return 0, ErrEventLoopUnsupported }
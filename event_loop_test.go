@@ -0,0 +1,188 @@
+//go:build linux
+
+package websocket_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+func TestEventLoop(t *testing.T) {
+	closed := make(chan error, 1)
+	l := EventLoop{
+		Workers: 2,
+		Handler: func(ws *WebSocket, opcode uint8, payload []byte) {
+			ws.WriteMessage(opcode, payload)
+		},
+		OnClose: func(ws *WebSocket, err error) { closed <- err },
+	}
+	defer l.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := Upgrader{Compression: &CompressionOptions{}}
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		if err = l.Add(ws); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	d := Dialer{Timeout: time.Second, Compression: &CompressionOptions{}}
+	ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	messages := [][]byte{
+		[]byte("hello"),
+		bytes.Repeat([]byte("ação"), 10000), // sent in fragments
+		{},
+	}
+	for _, msg := range messages {
+		if err = ws.WriteMessage(OpcodeText, msg); err != nil {
+			t.Fatal(err)
+		}
+		if err = ws.Ping([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := true, ws.Next(); want != got {
+			t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+		}
+		payload, opcode := ws.Message()
+		if want, got := uint8(OpcodeText), opcode; want != got {
+			t.Errorf("want %#x, got %#x", want, got)
+		}
+		if want, got := string(msg), string(payload); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+	if want, got := 1, l.Len(); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	ws.CloseWithReason(1000, "bye")
+	var cerr *CloseError
+	if want, got := true, errors.As(<-closed, &cerr) && cerr.Code == 1000; want != got {
+		t.Errorf("want %t, got %t", want, got)
+	}
+	if want, got := 0, l.Len(); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if want, got := true, errors.As(ws.Err(), &cerr) && cerr.Code == 1000; want != got {
+		t.Errorf("want %t, got %t (%v)", want, got, ws.Err())
+	}
+}
+
+func TestEventLoopReadLimits(t *testing.T) {
+	closed := make(chan error, 1)
+	l := EventLoop{OnClose: func(ws *WebSocket, err error) { closed <- err }}
+	defer l.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := Upgrader{ReadLimits: ReadLimits{MessageSize: 512}}
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		l.Add(ws)
+	}))
+	defer srv.Close()
+
+	ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+	ws.WriteMessage(OpcodeBinary, make([]byte, 1024))
+	if want, got := true, errors.Is(<-closed, ErrMessageTooBig); want != got {
+		t.Errorf("want %t, got %t", want, got)
+	}
+	ws.Next()
+	var cerr *CloseError
+	if want, got := true, errors.As(ws.Err(), &cerr) && cerr.Code == 1009; want != got {
+		t.Errorf("want %t, got %t (%v)", want, got, ws.Err())
+	}
+}
+
+func TestEventLoopCloseFromHandler(t *testing.T) {
+	closing := make(chan time.Duration, 1)
+	closed := make(chan error, 1)
+	l := EventLoop{
+		Workers: 1,
+		Handler: func(ws *WebSocket, opcode uint8, payload []byte) {
+			start := time.Now()
+			ws.CloseWithReason(1001, "bye")
+			closing <- time.Since(start)
+		},
+		OnClose: func(ws *WebSocket, err error) { closed <- err },
+	}
+	defer l.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := UpgradeHTTP(w, r)
+		if err != nil {
+			return
+		}
+		if err = l.Add(ws); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	ws.WriteMessage(OpcodeText, []byte("close"))
+	if want, got := false, ws.Next(); want != got {
+		t.Fatalf("want %t, got %t", want, got)
+	}
+	if d := <-closing; d > time.Second {
+		t.Errorf("want Close to return at once, took %v", d)
+	}
+	var cerr *CloseError
+	if want, got := true, errors.As(<-closed, &cerr) && cerr.Code == 1001; want != got {
+		t.Errorf("want %t, got %t", want, got)
+	}
+}
+
+func TestEventLoopAddClosed(t *testing.T) {
+	var l EventLoop
+	l.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := UpgradeHTTP(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		if want, got := ErrEventLoopClosed, l.Add(ws); want != got {
+			t.Errorf("want %v, got %v", want, got)
+		}
+		// The connection is still read as usual.
+		for ws.Next() {
+			payload, opcode := ws.Message()
+			ws.WriteMessage(opcode, payload)
+		}
+	}))
+	defer srv.Close()
+
+	ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+	ws.WriteMessage(OpcodeText, []byte("hello"))
+	if want, got := true, ws.Next(); want != got {
+		t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+	}
+	payload, _ := ws.Message()
+	if want, got := "hello", string(payload); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -9,9 +9,10 @@ import (
 )
 
 func main() {
+	u := websocket.Upgrader{Compression: &websocket.CompressionOptions{}}
 	http.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Server", "github.com/gbrlsnchs/websocket")
-		ws, err := websocket.UpgradeHTTP(w, r)
+		ws, err := u.Upgrade(w, r)
 		if err != nil {
 			fmt.Println(err)
 			return
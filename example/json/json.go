@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -21,16 +20,13 @@ func main() {
 			fmt.Println(err)
 			return
 		}
-		dec := json.NewDecoder(ws)
-		enc := json.NewEncoder(ws)
-		var t test
+		conn := websocket.NewTypedConn[test](ws, websocket.JSON)
 
-		for ws.Next() {
-			if err = dec.Decode(&t); err != nil {
+		for {
+			t, err := conn.Receive()
+			if err != nil {
 				fmt.Println(err)
-				ws.Write([]byte(err.Error()))
-				ws.Close()
-				return
+				break
 			}
 			switch t.Msg {
 			case "hello":
@@ -40,16 +36,12 @@ func main() {
 			default:
 				t.Msg = "dunno"
 			}
-			if err = enc.Encode(t); err != nil {
+			if err = conn.Send(t); err != nil {
 				fmt.Println(err)
-				ws.Write([]byte(err.Error()))
-				ws.Close()
-				return
+				break
 			}
 		}
-		if err = ws.Err(); err != nil {
-			fmt.Println(err)
-		}
+		ws.Close()
 		fmt.Println(ws.CloseCode())
 	}))
 	log.Fatal(http.ListenAndServe(":9001", nil))
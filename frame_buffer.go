@@ -5,178 +5,299 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
-	"unicode/utf8"
+	"math"
 )
 
 const (
 	leftBit    = 0x80
 	rsvBits    = 0x70
+	rsv1Bit    = 0x40
 	opcodeBits = 0xF
 	lengthBits = 0x7F
 )
 
+// Protocol errors, which are reported wrapped in a *ProtocolError.
 var (
-	errFragmentedControlFrame    = errors.New("websocket: fragmented control Frame")
-	errInvalidOpcode             = errors.New("websocket: invalid opcode")
-	errInvalidContinuationOpcode = errors.New("websocket: invalid opcode for continuation")
-	errHeadlessContinuation      = errors.New("websocket: headless continuation")
-	errUnmasked                  = errors.New("websocket: unmasked message sent from client")
-	errLargeControlFrame         = errors.New("websocket: control Frame with length greater than 125")
-	errUnnegotiatedRSV           = errors.New("websocket: unnegotiated RSV bits")
-	errInvalidClosePayload       = errors.New("websocket: invalid application data for opcode close")
-	errIllegalLength             = errors.New("websocket: illegal length indicator")
+	// ErrFragmentedControlFrame is reported when a control frame is not final.
+	ErrFragmentedControlFrame = errors.New("websocket: fragmented control Frame")
+	// ErrInvalidOpcode is reported when a frame uses a reserved opcode.
+	ErrInvalidOpcode = errors.New("websocket: invalid opcode")
+	// ErrInvalidContinuationOpcode is reported when a new message starts before the previous one ends.
+	ErrInvalidContinuationOpcode = errors.New("websocket: invalid opcode for continuation")
+	// ErrHeadlessContinuation is reported when a continuation frame doesn't continue any message.
+	ErrHeadlessContinuation = errors.New("websocket: headless continuation")
+	// ErrUnmasked is reported when a client sends an unmasked frame.
+	ErrUnmasked = errors.New("websocket: unmasked message sent from client")
+	// ErrMasked is reported when a server sends a masked frame.
+	ErrMasked = errors.New("websocket: masked message sent from server")
+	// ErrLargeControlFrame is reported when a control frame's payload is longer than 125 bytes.
+	ErrLargeControlFrame = errors.New("websocket: control Frame with length greater than 125")
+	// ErrUnnegotiatedRSV is reported when a frame sets RSV bits no extension accounts for.
+	ErrUnnegotiatedRSV = errors.New("websocket: unnegotiated RSV bits")
+	// ErrInvalidClosePayload is reported when a close frame's payload is a single byte.
+	ErrInvalidClosePayload = errors.New("websocket: invalid application data for opcode close")
+	// ErrIllegalLength is reported when a 64-bit payload length has its most significant bit set.
+	ErrIllegalLength = errors.New("websocket: illegal length indicator")
 )
 
-// frameBuffer is a sequence of frames buffered in a stack.
-type frameBuffer struct {
-	done    bool
-	first   bool
-	opcode  uint8
-	payload []byte
-	rd      *bufio.Reader
-	client  bool
-}
+// Errors for exceeded read limits, which are also reported wrapped in a *ProtocolError.
+var (
+	// ErrFrameTooBig is reported when a frame exceeds ReadLimits.FrameSize.
+	ErrFrameTooBig = errors.New("websocket: frame exceeds read limit")
+	// ErrMessageTooBig is reported when a message exceeds ReadLimits.MessageSize.
+	ErrMessageTooBig = errors.New("websocket: message exceeds read limit")
+	// ErrTooManyFragments is reported when a message exceeds ReadLimits.Fragments.
+	ErrTooManyFragments = errors.New("websocket: message exceeds fragment limit")
+)
 
-// Bytes returns the internal payload that was buffered
-// from the first frame until the final frame sequence.
-func (fb *frameBuffer) Bytes() []byte {
-	return fb.payload
+// ReadLimits bounds how much a peer can make a WebSocket allocate.
+// Zero values mean no limit.
+//
+// When a limit is exceeded, the connection is closed with close code 1009.
+type ReadLimits struct {
+	// FrameSize is the maximum payload length of a single frame.
+	FrameSize int64
+	// MessageSize is the maximum length of a message, both compressed and decompressed.
+	MessageSize int64
+	// Fragments is the maximum number of frames a message can be split in.
+	Fragments int
 }
 
-// Len returns the payload byte size.
-func (fb *frameBuffer) Len() int {
-	return len(fb.payload)
-}
+// frameBuffer reads frames from the connection,
+// keeping track of the message they belong to.
+type frameBuffer struct {
+	first     bool
+	rd        *bufio.Reader
+	client    bool
+	inflater  *inflater
+	limits    ReadLimits
+	fragments int
+	size      int64
 
-// Opcode returns whether the frame is binary or text.
-func (fb *frameBuffer) Opcode() uint8 {
-	return fb.opcode
-}
+	// h is the header of the current data frame,
+	// whose payload has remaining bytes left to be read.
+	h         header
+	remaining int64
+	pos       int
+	// err is reported when the rest of a message was discarded.
+	err error
 
-func (fb *frameBuffer) Read(b []byte) (int, error) {
-	return copy(b, fb.payload), nil
+	// control holds the payload of the last control frame,
+	// so that reading frames doesn't allocate.
+	control [125]byte
 }
 
-func (fb *frameBuffer) add(f *frame) {
-	if fb.first {
-		fb.opcode = f.opcode
-		fb.first = false
-	}
-	fb.payload = append(fb.payload, f.payload...)
-	fb.done = f.final
+// header is the decoded header of a frame.
+type header struct {
+	final      bool
+	compressed bool
+	opcode     uint8
+	length     int64
+	masked     bool
+	mask       mask
 }
 
-func (fb *frameBuffer) next() (*frame, error) {
+// readHeader reads and validates the next frame header.
+func (fb *frameBuffer) readHeader() (header, error) {
 	var (
+		h   header
 		b   byte
 		err error
 		rd  = fb.rd
 	)
 	// Check FIN, RSV 1 to 3 and the opcode.
 	if b, err = rd.ReadByte(); err != nil {
-		return nil, err
+		return h, err
 	}
 	fin, rsv, opcode := b&leftBit, b&rsvBits, b&opcodeBits
 	// Validate first byte.
 	switch {
 	case fin == 0 && opcode >= opcodeClose:
-		return nil, errFragmentedControlFrame
-	case rsv > 0:
-		return nil, errUnnegotiatedRSV
+		return h, ErrFragmentedControlFrame
+	// RSV1 marks the first frame of a compressed message when permessage-deflate is in use.
+	case rsv&^rsv1Bit > 0,
+		rsv > 0 && (fb.inflater == nil || opcode == opcodeContinuation || opcode >= opcodeClose):
+		return h, ErrUnnegotiatedRSV
 	case opcode < opcodeContinuation ||
 		opcode > OpcodeBinary && opcode < opcodeClose ||
 		opcode > opcodePong:
-		return nil, errInvalidOpcode
+		return h, ErrInvalidOpcode
 		// Previous frame is not final, current is neither continuation nor is a control frame.
 	case !fb.first &&
 		opcode > opcodeContinuation &&
 		opcode < opcodeClose:
-		return nil, errInvalidContinuationOpcode
-	case fb.opcode == opcodeContinuation && opcode == opcodeContinuation:
-		return nil, errHeadlessContinuation
+		return h, ErrInvalidContinuationOpcode
+	case fb.first && opcode == opcodeContinuation:
+		return h, ErrHeadlessContinuation
 	}
 
 	if b, err = rd.ReadByte(); err != nil {
-		return nil, err
+		return h, err
 	}
 	masked, length := b&leftBit, int(b&lengthBits)
-	if masked == 0 && !fb.client {
-		return nil, errUnmasked
+	switch {
+	case masked == 0 && !fb.client:
+		return h, ErrUnmasked
+	case masked != 0 && fb.client:
+		return h, ErrMasked
 	}
 	if opcode >= opcodeClose && length > 125 {
-		return nil, errLargeControlFrame
+		return h, ErrLargeControlFrame
 	}
 
-	// Read the payload according to the length indicator:
+	// Read the payload length according to the length indicator:
 	// 0 until 125 is the literal length.
 	// 126 means the length is indicated by an unsigned 16-bit integer.
 	// 127 means the length is indicated by an unsigned 64-bit integer.
-	var payload []byte
+	var size uint64
 	switch {
-	case length == 0: // no-op
-	case length > 0 && length <= 125:
-		payload = make([]byte, length)
+	case length <= 125:
+		size = uint64(length)
 	case length == 126:
-		b := make([]byte, 2)
-		if _, err = io.ReadFull(rd, b); err != nil {
-			return nil, err
+		p, err := fb.next(2)
+		if err != nil {
+			return h, err
 		}
-		payload = make([]byte, binary.BigEndian.Uint16(b))
+		size = uint64(binary.BigEndian.Uint16(p))
 	case length == 127:
-		b := make([]byte, 8)
-		if _, err = io.ReadFull(rd, b); err != nil {
-			return nil, err
+		p, err := fb.next(8)
+		if err != nil {
+			return h, err
+		}
+		// The most significant bit must be 0.
+		if size = binary.BigEndian.Uint64(p); size > math.MaxInt64 {
+			return h, ErrIllegalLength
 		}
-		payload = make([]byte, binary.BigEndian.Uint64(b))
 	default:
-		return nil, errIllegalLength
+		return h, ErrIllegalLength
+	}
+	// Enforce limits before anything is allocated for the payload.
+	if err = fb.checkLimits(opcode, size); err != nil {
+		return h, err
 	}
 
-	var m mask
-	if !fb.client {
-		// Unmask the payload.
-		m = make(mask, 4)
-		if _, err = io.ReadFull(rd, m); err != nil {
-			return nil, err
+	if masked != 0 {
+		p, err := fb.next(len(h.mask))
+		if err != nil {
+			return h, err
 		}
+		copy(h.mask[:], p)
 	}
-	length = len(payload)
-	f := &frame{
-		final:   fin != 0,
-		opcode:  opcode,
-		payload: payload,
+	h.final = fin != 0
+	h.compressed = rsv > 0
+	h.opcode = opcode
+	h.length = int64(size)
+	h.masked = masked != 0
+
+	// Keep track of the message that data frames belong to.
+	if opcode < opcodeClose {
+		if fb.first {
+			fb.fragments = 0
+			fb.size = 0
+		}
+		fb.first = h.final
+		fb.fragments++
+		fb.size += h.length
+		fb.h, fb.remaining, fb.pos = h, h.length, 0
 	}
-	if length > 0 {
-		if _, err = io.ReadFull(rd, payload); err != nil {
-			return nil, err
+	return h, nil
+}
+
+// next reads the next n bytes of a header straight from the read buffer.
+// They're only valid until the following read.
+func (fb *frameBuffer) next(n int) ([]byte, error) {
+	p, err := fb.rd.Peek(n)
+	if err != nil {
+		if err == io.EOF && len(p) > 0 {
+			err = io.ErrUnexpectedEOF
 		}
-		if !fb.client {
-			// Decode the payload according to the RFC 6455.
-			m.transform(payload)
+		return nil, err
+	}
+	_, err = fb.rd.Discard(n)
+	return p, err
+}
+
+// readPayload reads the payload of the current data frame.
+func (fb *frameBuffer) readPayload(b []byte) (int, error) {
+	if int64(len(b)) > fb.remaining {
+		b = b[:fb.remaining]
+	}
+	n, err := fb.rd.Read(b)
+	if fb.h.masked {
+		fb.pos = fb.h.mask.transform(b[:n], fb.pos)
+	}
+	fb.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// discard skips what's left of the current data frame's payload.
+func (fb *frameBuffer) discard() error {
+	for fb.remaining > 0 {
+		n := fb.remaining
+		if n > math.MaxInt32 {
+			n = math.MaxInt32
 		}
-		// Read close data if there's any.
-		if opcode == opcodeClose {
-			if length < 2 {
-				return nil, errInvalidClosePayload
-			}
-			f.hasCloseCode = true
-			f.cc = binary.BigEndian.Uint16(payload[:2])
-			f.payload = payload[2:]
+		m, err := fb.rd.Discard(int(n))
+		fb.remaining -= int64(m)
+		if err != nil {
+			return err
 		}
 	}
-	return f, nil
+	return nil
 }
 
-func (fb *frameBuffer) reset() {
-	fb.first = true
-	fb.done = false
-	fb.opcode = 0
-	fb.payload = nil
+// readFrame reads the whole payload of a control frame whose header was just read.
+// The payload is only valid until the next control frame is read.
+func (fb *frameBuffer) readFrame(h header) (frame, error) {
+	f := frame{
+		final:  h.final,
+		opcode: h.opcode,
+	}
+	if h.length == 0 {
+		return f, nil
+	}
+	payload := fb.control[:h.length]
+	if _, err := io.ReadFull(fb.rd, payload); err != nil {
+		return f, err
+	}
+	if h.masked {
+		// Decode the payload according to the RFC 6455.
+		h.mask.transform(payload, 0)
+	}
+	f.payload = payload
+	// Read close data if there's any.
+	if h.opcode == opcodeClose {
+		if h.length < 2 {
+			return f, ErrInvalidClosePayload
+		}
+		f.hasCloseCode = true
+		f.cc = binary.BigEndian.Uint16(payload[:2])
+		f.payload = payload[2:]
+	}
+	return f, nil
 }
 
-func (fb *frameBuffer) validate() error {
-	if fb.opcode == OpcodeText && !utf8.Valid(fb.payload) {
-		return errInvalidUTF8
+// checkLimits validates the size of the next frame against the read limits.
+func (fb *frameBuffer) checkLimits(opcode uint8, size uint64) error {
+	lim := fb.limits
+	if lim.FrameSize > 0 && size > uint64(lim.FrameSize) {
+		return ErrFrameTooBig
+	}
+	if opcode >= opcodeClose { // control frames are not part of the message
+		return nil
+	}
+	msgSize, fragments := uint64(fb.size), fb.fragments
+	if fb.first {
+		msgSize, fragments = 0, 0
+	}
+	switch {
+	case lim.MessageSize > 0 && msgSize+size > uint64(lim.MessageSize):
+		return ErrMessageTooBig
+	case lim.Fragments > 0 && fragments >= lim.Fragments:
+		return ErrTooManyFragments
 	}
 	return nil
 }
@@ -1,3 +1,10 @@
 module github.com/gbrlsnchs/websocket
 
-require github.com/gbrlsnchs/uuid v0.6.0
+go 1.18
+
+require (
+	github.com/gbrlsnchs/uuid v0.6.0
+	golang.org/x/net v0.33.0
+)
+
+require golang.org/x/text v0.21.0 // indirect
@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxHandshakeBody is how much of a rejected handshake's response body is kept.
+const maxHandshakeBody = 4096
+
+// HandshakeError is returned when dialing if the server replies
+// with a status other than 101 Switching Protocols.
+type HandshakeError struct {
+	// Response is the server's reply. Its body was already read and closed,
+	// and is replaced by a reader over Body.
+	Response *http.Response
+	// Body holds the first 4096 bytes of the response body at most.
+	Body []byte
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket: handshake failed with status %s", e.Response.Status)
+}
+
+// newHandshakeError reads a bounded copy of the body of a rejected handshake's response.
+func newHandshakeError(rr *http.Response) *HandshakeError {
+	body, _ := io.ReadAll(io.LimitReader(rr.Body, maxHandshakeBody))
+	rr.Body.Close()
+	rr.Body = io.NopCloser(bytes.NewReader(body))
+	return &HandshakeError{Response: rr, Body: body}
+}
@@ -0,0 +1,132 @@
+package websocket_test
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestUpgradeHTTP2(t *testing.T) {
+	srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := Upgrader{Subprotocols: []string{"chat"}}
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for ws.Next() {
+			payload, opcode := ws.Message()
+			ws.WriteMessage(opcode, payload)
+		}
+	}), &http2.Server{}))
+	defer srv.Close()
+
+	// Speak HTTP/2 with prior knowledge, sending the extended CONNECT request by hand.
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Write([]byte(http2.ClientPreface)); err != nil {
+		t.Fatal(err)
+	}
+	fr := http2.NewFramer(conn, conn)
+	fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	if err = fr.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	var hdr bytes.Buffer
+	enc := hpack.NewEncoder(&hdr)
+	for _, f := range [][2]string{
+		{":method", "CONNECT"},
+		{":protocol", "websocket"},
+		{":scheme", "http"},
+		{":path", "/"},
+		{":authority", addr},
+		{"sec-websocket-version", "13"},
+		{"sec-websocket-protocol", "chat"},
+	} {
+		enc.WriteField(hpack.HeaderField{Name: f[0], Value: f[1]})
+	}
+	err = fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: hdr.Bytes(), EndHeaders: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		data  bytes.Buffer
+		ended bool
+	)
+	// read reads frames until n bytes of the stream's data arrive.
+	read := func(n int) []byte {
+		for data.Len() < n && !ended {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch f := f.(type) {
+			case *http2.SettingsFrame:
+				if !f.IsAck() {
+					fr.WriteSettingsAck()
+				}
+			case *http2.MetaHeadersFrame:
+				if want, got := "200", f.PseudoValue("status"); want != got {
+					t.Fatalf("want %s, got %s", want, got)
+				}
+				var protocol string
+				for _, hf := range f.RegularFields() {
+					if hf.Name == "sec-websocket-protocol" {
+						protocol = hf.Value
+					}
+				}
+				if want, got := "chat", protocol; want != got {
+					t.Errorf("want %q, got %q", want, got)
+				}
+				ended = f.StreamEnded()
+			case *http2.DataFrame:
+				data.Write(f.Data())
+				ended = f.StreamEnded()
+			case *http2.RSTStreamFrame:
+				t.Fatalf("stream reset with %v", f.ErrCode)
+			}
+		}
+		return data.Next(n)
+	}
+
+	testCases := []struct {
+		send, recv []byte
+	}{
+		{
+			send: []byte{0x81, 0x85, 0, 0, 0, 0, 'h', 'e', 'l', 'l', 'o'},
+			recv: []byte{0x81, 0x05, 'h', 'e', 'l', 'l', 'o'},
+		},
+		{
+			send: []byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xe8},
+			recv: []byte{0x88, 0x02, 0x03, 0xe8},
+		},
+	}
+	for _, tc := range testCases {
+		if err = fr.WriteData(1, false, tc.send); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := tc.recv, read(len(tc.recv)); !bytes.Equal(want, got) {
+			t.Errorf("want %x, got %x", want, got)
+		}
+	}
+	// The stream ends once the handler is done with the WebSocket.
+	read(1)
+	if want, got := true, ended; want != got {
+		t.Errorf("want %t, got %t", want, got)
+	}
+}
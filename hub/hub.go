@@ -0,0 +1,244 @@
+// Package hub broadcasts messages to groups of WebSocket connections.
+package hub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gbrlsnchs/websocket"
+)
+
+const defaultQueueSize = 64
+
+// Policy decides what happens to a message sent to a connection whose queue is full.
+type Policy int
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new one.
+	DropOldest Policy = iota
+	// DropNewest discards the new message.
+	DropNewest
+	// Disconnect unregisters the connection and closes it with close code 1008.
+	Disconnect
+)
+
+// Hub registers WebSocket connections, groups them into named rooms
+// and sends them messages through a bounded queue per connection,
+// so that slow connections don't hold back the others.
+// The zero value is ready to use.
+//
+// Reading messages is left to the caller, who should unregister
+// a connection once it's done with it, e.g. when Next returns false.
+type Hub struct {
+	// QueueSize is the number of messages queued for each connection.
+	// Zero means 64.
+	QueueSize int
+	// Policy is applied when a connection's queue is full.
+	Policy Policy
+	// WriteTimeout limits how long writing a single message may take.
+	// Zero means no timeout.
+	WriteTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[*websocket.WebSocket]*client
+	rooms map[string]map[*client]struct{}
+}
+
+// client is a registered connection and its send queue.
+type client struct {
+	ws    *websocket.WebSocket
+	queue chan *websocket.PreparedMessage
+	rooms map[string]struct{}
+	done  chan struct{}
+}
+
+// Register adds ws to the hub and starts sending it queued messages.
+// Registering a connection twice is a no-op.
+func (h *Hub) Register(ws *websocket.WebSocket) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[ws]; ok {
+		return
+	}
+	if h.conns == nil {
+		h.conns = make(map[*websocket.WebSocket]*client)
+		h.rooms = make(map[string]map[*client]struct{})
+	}
+	size := h.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	c := &client{
+		ws:    ws,
+		queue: make(chan *websocket.PreparedMessage, size),
+		rooms: make(map[string]struct{}),
+		done:  make(chan struct{}),
+	}
+	h.conns[ws] = c
+	go h.write(c)
+}
+
+// Unregister removes ws from the hub and all of its rooms.
+// Messages still queued for it are discarded.
+func (h *Hub) Unregister(ws *websocket.WebSocket) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unregister(ws)
+}
+
+// unregister removes ws from the hub. The caller must hold h.mu.
+func (h *Hub) unregister(ws *websocket.WebSocket) *client {
+	c, ok := h.conns[ws]
+	if !ok {
+		return nil
+	}
+	delete(h.conns, ws)
+	for room := range c.rooms {
+		h.leave(c, room)
+	}
+	close(c.done)
+	return c
+}
+
+// Join adds a registered connection to room.
+func (h *Hub) Join(ws *websocket.WebSocket, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.conns[ws]
+	if !ok {
+		return
+	}
+	members := h.rooms[room]
+	if members == nil {
+		members = make(map[*client]struct{})
+		h.rooms[room] = members
+	}
+	members[c] = struct{}{}
+	c.rooms[room] = struct{}{}
+}
+
+// Leave removes a connection from room.
+func (h *Hub) Leave(ws *websocket.WebSocket, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c, ok := h.conns[ws]; ok {
+		h.leave(c, room)
+	}
+}
+
+// leave removes c from room, dropping the room once it's empty.
+// The caller must hold h.mu.
+func (h *Hub) leave(c *client, room string) {
+	delete(c.rooms, room)
+	members := h.rooms[room]
+	delete(members, c)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// Len returns the number of registered connections.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+// Send queues a message for a registered connection.
+func (h *Hub) Send(ws *websocket.WebSocket, opcode uint8, b []byte) error {
+	pm, err := websocket.NewPreparedMessage(opcode, b)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c, ok := h.conns[ws]; ok {
+		h.enqueue(c, pm)
+	}
+	return nil
+}
+
+// Broadcast queues a message for every registered connection.
+// The message is encoded once for all of them.
+func (h *Hub) Broadcast(opcode uint8, b []byte) error {
+	pm, err := websocket.NewPreparedMessage(opcode, b)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.conns {
+		h.enqueue(c, pm)
+	}
+	return nil
+}
+
+// BroadcastTo queues a message for every connection in room.
+// The message is encoded once for all of them.
+func (h *Hub) BroadcastTo(room string, opcode uint8, b []byte) error {
+	pm, err := websocket.NewPreparedMessage(opcode, b)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.rooms[room] {
+		h.enqueue(c, pm)
+	}
+	return nil
+}
+
+// enqueue queues m for c, applying the policy if its queue is full.
+// The caller must hold h.mu.
+func (h *Hub) enqueue(c *client, m *websocket.PreparedMessage) {
+	if c.enqueue(m, h.Policy) {
+		return
+	}
+	// The connection can't keep up, so let it go.
+	h.unregister(c.ws)
+	go c.ws.CloseWithReason(1008, "slow consumer")
+}
+
+// enqueue queues m, reporting false if it couldn't be queued under the Disconnect policy.
+func (c *client) enqueue(m *websocket.PreparedMessage, p Policy) bool {
+	for {
+		select {
+		case c.queue <- m:
+			return true
+		default:
+		}
+		switch p {
+		case DropNewest:
+			return true
+		case Disconnect:
+			return false
+		}
+		// Make room by dropping the oldest message, unless the writer just did.
+		select {
+		case <-c.queue:
+		default:
+		}
+	}
+}
+
+// write sends queued messages to c until it's unregistered.
+func (h *Hub) write(c *client) {
+	for {
+		select {
+		case m := <-c.queue:
+			if h.WriteTimeout > 0 {
+				c.ws.SetWriteDeadline(time.Now().Add(h.WriteTimeout))
+			}
+			if err := c.ws.WritePreparedMessage(m); err != nil {
+				h.mu.Lock()
+				if h.conns[c.ws] == c {
+					h.unregister(c.ws)
+				}
+				h.mu.Unlock()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
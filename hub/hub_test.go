@@ -0,0 +1,107 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbrlsnchs/websocket"
+)
+
+func TestHub(t *testing.T) {
+	var h Hub
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.UpgradeHTTP(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		h.Register(ws)
+		defer h.Unregister(ws)
+		h.Join(ws, r.URL.Query().Get("room"))
+		for ws.Next() {
+			payload, opcode := ws.Message()
+			h.BroadcastTo(r.URL.Query().Get("room"), opcode, payload)
+		}
+	}))
+	defer srv.Close()
+
+	dial := func(room string) *websocket.WebSocket {
+		ws, err := websocket.Open(strings.Replace(srv.URL, "http", "ws", 1)+"/?room="+room, time.Second)
+		if want, got := (error)(nil), err; want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		ws.SetDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+	a1, a2, b := dial("a"), dial("a"), dial("b")
+	defer a1.Close()
+	defer a2.Close()
+	defer b.Close()
+	for h.Len() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.WriteMessage(websocket.OpcodeText, []byte("to b"))
+	a1.WriteMessage(websocket.OpcodeText, []byte("to a"))
+	for _, ws := range []*websocket.WebSocket{a1, a2, b} {
+		if want, got := true, ws.Next(); want != got {
+			t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+		}
+	}
+	for i, ws := range []*websocket.WebSocket{a1, a2, b} {
+		want := "to a"
+		if i == 2 {
+			want = "to b"
+		}
+		if payload, _ := ws.Message(); want != string(payload) {
+			t.Errorf("want %q, got %q", want, payload)
+		}
+	}
+
+	h.Broadcast(websocket.OpcodeText, []byte("to all"))
+	for _, ws := range []*websocket.WebSocket{a1, a2, b} {
+		if want, got := true, ws.Next(); want != got {
+			t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+		}
+		if payload, _ := ws.Message(); string(payload) != "to all" {
+			t.Errorf("want %q, got %q", "to all", payload)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	testCases := []struct {
+		policy Policy
+		queued []string
+		ok     bool
+	}{
+		{policy: DropOldest, queued: []string{"2", "3"}, ok: true},
+		{policy: DropNewest, queued: []string{"1", "2"}, ok: true},
+		{policy: Disconnect, queued: []string{"1", "2"}, ok: false},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			c := &client{queue: make(chan *websocket.PreparedMessage, 2)}
+			names := make(map[*websocket.PreparedMessage]string)
+			ok := true
+			for _, s := range []string{"1", "2", "3"} {
+				pm, _ := websocket.NewPreparedMessage(websocket.OpcodeText, []byte(s))
+				names[pm] = s
+				ok = c.enqueue(pm, tc.policy)
+			}
+			if want, got := tc.ok, ok; want != got {
+				t.Errorf("want %t, got %t", want, got)
+			}
+			var queued []string
+			for len(c.queue) > 0 {
+				queued = append(queued, names[<-c.queue])
+			}
+			if want, got := strings.Join(tc.queued, ","), strings.Join(queued, ","); want != got {
+				t.Errorf("want %s, got %s", want, got)
+			}
+		})
+	}
+}
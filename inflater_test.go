@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// compressMessages compresses msgs with context takeover, as sent over a connection.
+func compressMessages(msgs [][]byte) [][]byte {
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	out := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		buf.Reset()
+		fw.Write(msg)
+		fw.Flush()
+		out[i] = append([]byte(nil), bytes.TrimSuffix(buf.Bytes(), flushMarker)...)
+	}
+	return out
+}
+
+func TestInflaterTakeover(t *testing.T) {
+	// Messages refer back to earlier ones, and together span many windows.
+	var msgs [][]byte
+	for i := 0; i < 64; i++ {
+		var msg bytes.Buffer
+		for j := 0; j < 256; j++ {
+			fmt.Fprintf(&msg, "%d:%d ", j, (i*j)%97)
+		}
+		msgs = append(msgs, msg.Bytes())
+	}
+	i := &inflater{takeover: true}
+	for k, compressed := range compressMessages(msgs) {
+		rd, err := i.reader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Small reads make the dictionary grow a little at a time.
+		got, err := io.ReadAll(iotest.OneByteReader(rd))
+		if err != nil {
+			t.Fatalf("message %d: %v", k, err)
+		}
+		if want := msgs[k]; !bytes.Equal(want, got) {
+			t.Fatalf("message %d: want %d bytes, got %d bytes", k, len(want), len(got))
+		}
+	}
+}
+
+func BenchmarkInflaterSmallReads(b *testing.B) {
+	msg := bytes.Repeat([]byte("hello, compression! "), 64*1024/20)
+	compressed := compressMessages([][]byte{msg})[0]
+	buf := make([]byte, 16)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := &inflater{takeover: true}
+		rd, err := i.reader(bytes.NewReader(compressed))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for err == nil {
+			_, err = rd.Read(buf)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var ErrMalformedExtensions = errors.New("websocket: malformed Sec-WebSocket-Extensions header")
+
+// Extension is an element of a Sec-WebSocket-Extensions header.
+type Extension struct {
+	Name   string
+	Params []Param
+}
+
+// Param is an extension parameter. Value is empty when the parameter has no value.
+type Param struct {
+	Name  string
+	Value string
+}
+
+// String formats the extension the way it is sent in a header.
+func (e Extension) String() string {
+	var sb strings.Builder
+	sb.WriteString(e.Name)
+	for _, p := range e.Params {
+		sb.WriteString("; ")
+		sb.WriteString(p.Name)
+		if p.Value != "" {
+			sb.WriteByte('=')
+			sb.WriteString(p.Value)
+		}
+	}
+	return sb.String()
+}
+
+// ParseExtensions parses every Sec-WebSocket-Extensions header field in hdr,
+// preserving the order in which extensions were listed.
+func ParseExtensions(hdr http.Header) ([]Extension, error) {
+	var exts []Extension
+	for _, v := range hdr["Sec-Websocket-Extensions"] {
+		for _, elem := range splitQuoted(v, ',') {
+			if elem = strings.TrimSpace(elem); elem == "" {
+				continue
+			}
+			parts := splitQuoted(elem, ';')
+			ext := Extension{Name: strings.TrimSpace(parts[0])}
+			if !isToken(ext.Name) {
+				return nil, ErrMalformedExtensions
+			}
+			for _, part := range parts[1:] {
+				var p Param
+				if i := strings.IndexByte(part, '='); i >= 0 {
+					p.Name = strings.TrimSpace(part[:i])
+					p.Value = strings.TrimSpace(part[i+1:])
+					if n := len(p.Value); n >= 2 && p.Value[0] == '"' && p.Value[n-1] == '"' {
+						p.Value = p.Value[1 : n-1]
+					}
+					if !isToken(p.Value) {
+						return nil, ErrMalformedExtensions
+					}
+				} else {
+					p.Name = strings.TrimSpace(part)
+				}
+				if !isToken(p.Name) {
+					return nil, ErrMalformedExtensions
+				}
+				ext.Params = append(ext.Params, p)
+			}
+			exts = append(exts, ext)
+		}
+	}
+	return exts, nil
+}
+
+// splitQuoted splits s around sep, ignoring separators inside quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && quoted:
+			i++
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// isToken reports whether s is a valid token as defined by RFC 7230.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7F || strings.IndexByte(`()<>@,;:\"/[]?={}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package internal_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	. "github.com/gbrlsnchs/websocket/internal"
+)
+
+func TestParseExtensions(t *testing.T) {
+	testCases := []struct {
+		values []string
+		exts   []Extension
+		err    error
+	}{
+		{values: nil, exts: nil},
+		{
+			values: []string{`permessage-deflate; client_max_window_bits, permessage-deflate; server_max_window_bits="10"`},
+			exts: []Extension{
+				{Name: "permessage-deflate", Params: []Param{{Name: "client_max_window_bits"}}},
+				{Name: "permessage-deflate", Params: []Param{{Name: "server_max_window_bits", Value: "10"}}},
+			},
+		},
+		{
+			values: []string{"foo", "bar; baz=qux"},
+			exts: []Extension{
+				{Name: "foo"},
+				{Name: "bar", Params: []Param{{Name: "baz", Value: "qux"}}},
+			},
+		},
+		{values: []string{"foo; =bar"}, err: ErrMalformedExtensions},
+		{values: []string{"foo bar"}, err: ErrMalformedExtensions},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			hdr := http.Header{"Sec-Websocket-Extensions": tc.values}
+			exts, err := ParseExtensions(hdr)
+			if want, got := tc.err, err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			if want, got := tc.exts, exts; !reflect.DeepEqual(want, got) {
+				t.Errorf("want %v, got %v", want, got)
+			}
+		})
+	}
+}
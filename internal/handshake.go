@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"bufio"
 	"crypto/sha1"
 	"encoding/base64"
 	"errors"
@@ -17,51 +18,151 @@ const (
 )
 
 var (
+	ErrMethodNotAllowed           = errors.New("websocket: method not allowed")
+	ErrNotHijackable              = errors.New("websocket: connection not hijackable")
+	ErrNotFlushable               = errors.New("websocket: response not flushable")
+	ErrProtocolMismatch           = errors.New("websocket: :protocol pseudo-header mismatch")
 	ErrMissingHost                = errors.New("websocket: missing Host header")
 	ErrUpgradeMismatch            = errors.New("websocket: Upgrade header mismatch")
 	ErrConnectionMismatch         = errors.New("websocket: Connection header mismatch")
 	ErrSecWebSocketVersionMissing = errors.New("websocket: missing Sec-WebSocket-Version header")
 	ErrInvalidSecWebSocketKey     = errors.New("websocket: invalid Sec-WebSocket-Key")
 	ErrSecWebSocketKeyMismatch    = errors.New("websocket: key mismatch")
+	ErrSubprotocolMismatch        = errors.New("websocket: server selected a subprotocol not offered")
 )
 
-func Handshake(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+// Handshake validates the opening handshake and hijacks the connection.
+// Headers in hdr are added to the 101 response, such as negotiated extensions.
+// The returned buffers hold whatever the client sent right after the handshake.
+//
+// Over HTTP/2, the handshake is an extended CONNECT request (RFC 8441)
+// and the returned connection is carried by its stream, without any buffers.
+//
+// If the handshake fails, reject is called with the HTTP status to reply with.
+// If reject is nil, the status text is replied.
+func Handshake(w http.ResponseWriter, r *http.Request, hdr http.Header, reject func(int, error)) (net.Conn, *bufio.ReadWriter, error) {
+	if reject == nil {
+		reject = func(status int, _ error) {
+			http.Error(w, http.StatusText(status), status)
+		}
+	}
+	if r.ProtoMajor == 2 {
+		conn, err := handshakeHTTP2(w, r, hdr, reject)
+		return conn, nil, err
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		reject(http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return nil, nil, ErrMethodNotAllowed
+	}
 	if r.Host == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		return nil, ErrMissingHost
+		reject(http.StatusBadRequest, ErrMissingHost)
+		return nil, nil, ErrMissingHost
 	}
 
 	var err error
 	if err = validateClientHeaders(r.Header); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return nil, err
+		status := http.StatusBadRequest
+		if err == ErrSecWebSocketVersionMissing {
+			// Let the client know which version is supported.
+			w.Header().Set("Sec-WebSocket-Version", SecWebSocketVersionHeader)
+			status = http.StatusUpgradeRequired
+		}
+		reject(status, err)
+		return nil, nil, err
 	}
 
-	hdr := w.Header()
-	hdr.Set("Upgrade", "websocket")
-	hdr.Set("Connection", "Upgrade")
 	key, err := ConcatKey(r.Header.Get("Sec-WebSocket-Key"))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return nil, err
+		reject(http.StatusBadRequest, err)
+		return nil, nil, err
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		reject(http.StatusInternalServerError, ErrNotHijackable)
+		return nil, nil, ErrNotHijackable
 	}
-	hdr.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(key))
+	resHdr := w.Header()
+	for k, v := range hdr {
+		resHdr[k] = v
+	}
+	resHdr.Set("Upgrade", "websocket")
+	resHdr.Set("Connection", "Upgrade")
+	resHdr.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(key))
 
 	// Hijack the underlying connection.
-	if hj, ok := w.(http.Hijacker); ok {
-		w.WriteHeader(http.StatusSwitchingProtocols)
-		conn, bufrw, err := hj.Hijack()
-		if err != nil {
-			return nil, err
+	w.WriteHeader(http.StatusSwitchingProtocols)
+	conn, bufrw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = bufrw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, bufrw, nil
+}
+
+// handshakeHTTP2 validates an extended CONNECT request and replies to it.
+func handshakeHTTP2(w http.ResponseWriter, r *http.Request, hdr http.Header, reject func(int, error)) (net.Conn, error) {
+	if r.Method != http.MethodConnect {
+		w.Header().Set("Allow", http.MethodConnect)
+		reject(http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		return nil, ErrMethodNotAllowed
+	}
+	if r.Host == "" {
+		reject(http.StatusBadRequest, ErrMissingHost)
+		return nil, ErrMissingHost
+	}
+	if r.Header.Get(":protocol") != UpgradeHeader {
+		reject(http.StatusBadRequest, ErrProtocolMismatch)
+		return nil, ErrProtocolMismatch
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != SecWebSocketVersionHeader {
+		w.Header().Set("Sec-WebSocket-Version", SecWebSocketVersionHeader)
+		reject(http.StatusUpgradeRequired, ErrSecWebSocketVersionMissing)
+		return nil, ErrSecWebSocketVersionMissing
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		reject(http.StatusInternalServerError, ErrNotFlushable)
+		return nil, ErrNotFlushable
+	}
+	resHdr := w.Header()
+	for k, v := range hdr {
+		resHdr[k] = v
+	}
+	// There's no key to accept, a successful response is enough.
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	return newStreamConn(w, f, r), nil
+}
+
+// Subprotocols returns the subprotocols listed in the Sec-WebSocket-Protocol header fields.
+func Subprotocols(hdr http.Header) []string {
+	var protocols []string
+	for _, v := range hdr["Sec-Websocket-Protocol"] {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
 		}
-		if err = bufrw.Flush(); err != nil {
-			conn.Close()
-			return nil, err
+	}
+	return protocols
+}
+
+// SelectSubprotocol returns the first supported subprotocol also offered by the client.
+// Supported subprotocols are listed in order of preference.
+func SelectSubprotocol(r *http.Request, supported []string) string {
+	offered := Subprotocols(r.Header)
+	for _, p := range supported {
+		for _, o := range offered {
+			if p == o {
+				return p
+			}
 		}
-		return conn, nil
 	}
-	w.WriteHeader(http.StatusBadRequest)
-	return nil, errors.New("websocket: connection not hijackable")
+	return ""
 }
 
 func ConcatKey(key string) ([]byte, error) {
@@ -1,6 +1,7 @@
 package internal_test
 
 import (
+	"bufio"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -10,7 +11,7 @@ import (
 
 func TestHandshake(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn, err := Handshake(w, r)
+		conn, _, err := Handshake(w, r, nil, nil)
 		if err != nil {
 			return
 		}
@@ -52,7 +53,7 @@ func TestHandshake(t *testing.T) {
 			connection:   "upgrade",
 			secWSVersion: "baz",
 			secWSKey:     "dGhlIHNhbXBsZSBub25jZQ==",
-			status:       http.StatusBadRequest,
+			status:       http.StatusUpgradeRequired,
 		},
 		{
 			upgrade:      "websocket",
@@ -84,3 +85,59 @@ func TestHandshake(t *testing.T) {
 		})
 	}
 }
+
+func TestHandshakeHTTP2(t *testing.T) {
+	testCases := []struct {
+		method       string
+		protocol     string
+		secWSVersion string
+		status       int
+	}{
+		{method: http.MethodConnect, protocol: "websocket", secWSVersion: "13", status: http.StatusOK},
+		{method: http.MethodGet, protocol: "websocket", secWSVersion: "13", status: http.StatusMethodNotAllowed},
+		{method: http.MethodConnect, protocol: "foo", secWSVersion: "13", status: http.StatusBadRequest},
+		{method: http.MethodConnect, protocol: "websocket", secWSVersion: "baz", status: http.StatusUpgradeRequired},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/", nil)
+			r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+			r.Header.Set(":protocol", tc.protocol)
+			r.Header.Set("Sec-WebSocket-Version", tc.secWSVersion)
+			w := httptest.NewRecorder()
+			conn, bufrw, err := Handshake(w, r, nil, nil)
+			if want, got := tc.status, w.Code; want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+			if want, got := tc.status == http.StatusOK, err == nil && conn != nil; want != got {
+				t.Errorf("want %t, got %t (%v)", want, got, err)
+			}
+			if want, got := (*bufio.ReadWriter)(nil), bufrw; want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestSelectSubprotocol(t *testing.T) {
+	testCases := []struct {
+		offered   []string
+		supported []string
+		want      string
+	}{
+		{offered: nil, supported: []string{"v1.json"}, want: ""},
+		{offered: []string{"v1.json"}, supported: nil, want: ""},
+		{offered: []string{"v1.json, v2.msgpack"}, supported: []string{"v2.msgpack", "v1.json"}, want: "v2.msgpack"},
+		{offered: []string{"v1.json", "v2.msgpack"}, supported: []string{"v1.json"}, want: "v1.json"},
+		{offered: []string{"v3.xml"}, supported: []string{"v1.json", "v2.msgpack"}, want: ""},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header["Sec-Websocket-Protocol"] = tc.offered
+			if want, got := tc.want, SelectSubprotocol(r, tc.supported); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrDeadlineNotSupported = errors.New("websocket: deadlines not supported by the HTTP/2 server")
+
+// streamConn is a net.Conn carried by an HTTP/2 stream,
+// reading from the request body and writing to the response.
+// The stream ends once the handler returns.
+type streamConn struct {
+	w      http.ResponseWriter
+	f      http.Flusher
+	body   io.ReadCloser
+	local  net.Addr
+	remote net.Addr
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newStreamConn(w http.ResponseWriter, f http.Flusher, r *http.Request) *streamConn {
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if local == nil {
+		local = addr("")
+	}
+	return &streamConn{
+		w:      w,
+		f:      f,
+		body:   r.Body,
+		local:  local,
+		remote: addr(r.RemoteAddr),
+	}
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	n, err := c.body.Read(b)
+	if err != nil && c.isClosed() {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+	n, err := c.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	// Frames must reach the peer right away rather than when the handler returns.
+	if fe, ok := c.w.(interface{ FlushError() error }); ok {
+		return n, fe.FlushError()
+	}
+	c.f.Flush()
+	return n, nil
+}
+
+// Close stops reading from the stream. It's only finished once the handler returns.
+func (c *streamConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	return c.body.Close()
+}
+
+func (c *streamConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline and SetWriteDeadline work with servers that support them,
+// the same way as http.ResponseController.
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.w.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return ErrDeadlineNotSupported
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return ErrDeadlineNotSupported
+}
+
+// addr is the address of the peer as reported by http.Request.RemoteAddr.
+type addr string
+
+func (a addr) Network() string { return "tcp" }
+func (a addr) String() string  { return string(a) }
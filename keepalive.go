@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrPongTimeout is reported when the peer doesn't answer a keepalive ping in time.
+var ErrPongTimeout = errors.New("websocket: keepalive ping timed out")
+
+// KeepAlive configures pings sent periodically to detect dead connections.
+//
+// Pongs are only received while messages are being read,
+// so some goroutine must keep calling Next or NextReader.
+type KeepAlive struct {
+	// Interval is how often pings are sent. Zero disables keepalive pings.
+	Interval time.Duration
+	// Timeout is how long to wait for a pong before dropping the connection.
+	// Zero means the same as Interval.
+	Timeout time.Duration
+}
+
+// Ping sends a ping with a payload of up to 125 bytes.
+func (ws *WebSocket) Ping(b []byte) error { return ws.writeControl(opcodePing, b) }
+
+// SetPingHandler sets the function called with the payload of received pings.
+// The default handler, restored by passing nil, answers with a pong.
+//
+// Handlers are called by the goroutine reading messages
+// and returning an error drops the connection.
+// The payload is only valid until the handler returns.
+func (ws *WebSocket) SetPingHandler(h func(payload []byte) error) { ws.pingHandler = h }
+
+// SetPongHandler sets the function called with the payload of received pongs.
+// Keepalive pongs are accounted for before calling it.
+func (ws *WebSocket) SetPongHandler(h func(payload []byte) error) { ws.pongHandler = h }
+
+// RTT returns the round-trip time measured by the last keepalive ping.
+// It's zero until a keepalive pong is received.
+func (ws *WebSocket) RTT() time.Duration {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.rtt
+}
+
+// SetKeepAlive starts sending keepalive pings, replacing the previous configuration.
+func (ws *WebSocket) SetKeepAlive(ka KeepAlive) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.stopKeepAlive != nil {
+		close(ws.stopKeepAlive)
+		ws.stopKeepAlive = nil
+	}
+	if ka.Interval <= 0 || ws.state == stateClosed {
+		return
+	}
+	if ka.Timeout <= 0 {
+		ka.Timeout = ka.Interval
+	}
+	ws.stopKeepAlive = make(chan struct{})
+	go ws.keepAlive(ka, ws.stopKeepAlive)
+}
+
+func (ws *WebSocket) keepAlive(ka KeepAlive, stop <-chan struct{}) {
+	ticker := time.NewTicker(ka.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-ws.done:
+			return
+		}
+
+		ws.mu.Lock()
+		if ws.pongTimer != nil { // still waiting for the previous pong
+			ws.mu.Unlock()
+			continue
+		}
+		ws.pingSent = time.Now()
+		binary.BigEndian.PutUint64(ws.pingPayload[:], uint64(ws.pingSent.UnixNano()))
+		payload := ws.pingPayload
+		ws.pongTimer = time.AfterFunc(ka.Timeout, ws.pongTimeout)
+		ws.mu.Unlock()
+
+		if err := ws.writeControl(opcodePing, payload[:]); err != nil {
+			return
+		}
+	}
+}
+
+// pongTimeout drops the connection when a keepalive pong doesn't arrive in time.
+func (ws *WebSocket) pongTimeout() {
+	ws.mu.Lock()
+	if ws.pongTimer == nil || ws.state == stateClosed {
+		ws.mu.Unlock()
+		return
+	}
+	ws.pongTimer = nil
+	if ws.err == nil {
+		ws.err = ErrPongTimeout
+	}
+	ws.setClosed()
+	ws.mu.Unlock()
+	ws.conn.Close()
+}
+
+func (ws *WebSocket) handlePing(b []byte) error {
+	if ws.pingHandler != nil {
+		return ws.pingHandler(b)
+	}
+	ws.writeControl(opcodePong, b)
+	return nil
+}
+
+func (ws *WebSocket) handlePong(b []byte) error {
+	ws.mu.Lock()
+	if ws.pongTimer != nil && bytes.Equal(b, ws.pingPayload[:]) {
+		ws.pongTimer.Stop()
+		ws.pongTimer = nil
+		ws.rtt = time.Since(ws.pingSent)
+	}
+	ws.mu.Unlock()
+	if ws.pongHandler != nil {
+		return ws.pongHandler(b)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package websocket_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+func TestKeepAlive(t *testing.T) {
+	testCases := []struct {
+		clientReads bool
+		err         error
+	}{
+		{clientReads: true, err: nil},
+		{clientReads: false, err: ErrPongTimeout},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			type result struct {
+				pongs int
+				rtt   time.Duration
+				err   error
+			}
+			results := make(chan result, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				u := Upgrader{KeepAlive: KeepAlive{Interval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond}}
+				ws, err := u.Upgrade(w, r)
+				if err != nil {
+					return
+				}
+				defer ws.Close()
+				var res result
+				ws.SetPongHandler(func([]byte) error {
+					if res.pongs++; res.pongs == 3 {
+						ws.SetReadDeadline(time.Now()) // stop reading
+					}
+					return nil
+				})
+				for ws.Next() {
+				}
+				if res.err = ws.Err(); res.err != ErrPongTimeout {
+					res.err = nil
+				}
+				res.rtt = ws.RTT()
+				results <- res
+			}))
+			defer srv.Close()
+
+			ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			defer ws.Close()
+			if tc.clientReads {
+				go func() {
+					for ws.Next() { // answers pings
+					}
+				}()
+			}
+
+			res := <-results
+			if want, got := tc.err, res.err; want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+			if !tc.clientReads {
+				return
+			}
+			if want, got := 3, res.pongs; want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+			if want, got := true, res.rtt > 0; want != got {
+				t.Errorf("want %t, got %t", want, got)
+			}
+		})
+	}
+}
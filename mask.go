@@ -1,9 +1,59 @@
 package websocket
 
-type mask []byte
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
 
-func (m mask) transform(b []byte) {
+type mask [4]byte
+
+// transform masks or unmasks b, which starts at position pos of the payload.
+// It returns the position following b.
+func (m *mask) transform(b []byte, pos int) int {
+	end := pos + len(b)
+	// Rotate the key so that it starts at b[0].
+	var key [4]byte
+	for i := range key {
+		key[i] = m[(pos+i)&3]
+	}
+	// XOR eight bytes at a time, since the key repeats every four bytes.
+	k32 := binary.LittleEndian.Uint32(key[:])
+	k64 := uint64(k32)<<32 | uint64(k32)
+	for len(b) >= 32 {
+		binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^k64)
+		binary.LittleEndian.PutUint64(b[8:], binary.LittleEndian.Uint64(b[8:])^k64)
+		binary.LittleEndian.PutUint64(b[16:], binary.LittleEndian.Uint64(b[16:])^k64)
+		binary.LittleEndian.PutUint64(b[24:], binary.LittleEndian.Uint64(b[24:])^k64)
+		b = b[32:]
+	}
+	for len(b) >= 8 {
+		binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^k64)
+		b = b[8:]
+	}
 	for i := range b {
-		b[i] ^= m[i%4]
+		b[i] ^= key[i&3]
+	}
+	return end
+}
+
+// maskKeys hands out masking keys read from crypto/rand in batches,
+// which saves a system call for every frame while keeping keys unpredictable.
+// The zero value is ready to use.
+type maskKeys struct {
+	buf [256]byte
+	n   int // keys left in buf, counting from its end
+}
+
+func (k *maskKeys) next() (mask, error) {
+	var m mask
+	if k.n == 0 {
+		if _, err := io.ReadFull(rand.Reader, k.buf[:]); err != nil {
+			return m, err
+		}
+		k.n = len(k.buf) / len(m)
 	}
+	k.n--
+	copy(m[:], k.buf[k.n*len(m):])
+	return m, nil
 }
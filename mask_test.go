@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestMaskTransform(t *testing.T) {
+	m := mask{0x12, 0x34, 0x56, 0x78}
+	for size := 0; size < 100; size++ {
+		for pos := 0; pos < 4; pos++ {
+			b := make([]byte, size)
+			rand.Read(b)
+			want := append([]byte(nil), b...)
+			for i := range want {
+				want[i] ^= m[(pos+i)%4]
+			}
+			if want, got := pos+size, m.transform(b, pos); want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+			if want, got := want, b; !bytes.Equal(want, got) {
+				t.Errorf("size=%d, pos=%d: want %x, got %x", size, pos, want, got)
+			}
+		}
+	}
+}
+
+func TestMaskKeys(t *testing.T) {
+	var keys maskKeys
+	seen := make(map[mask]bool)
+	// Go through a couple of batches.
+	for i := 0; i < 2*len(keys.buf)/4; i++ {
+		m, err := keys.next()
+		if want, got := (error)(nil), err; want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		seen[m] = true
+	}
+	// Collisions are possible but astronomically unlikely.
+	if want, got := 2*len(keys.buf)/4, len(seen); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+}
+
+// captureConn records everything written to it.
+type captureConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *captureConn) Write(b []byte) (int, error) { return c.buf.Write(b) }
+
+func TestClientWriteKeepsPayload(t *testing.T) {
+	for _, size := range []int{16, 8192} {
+		conn := &captureConn{}
+		ws := newTestWS(conn, true)
+		payload := bytes.Repeat([]byte("hello"), size/5)
+		orig := append([]byte(nil), payload...)
+		if err := ws.WriteMessage(OpcodeBinary, payload); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := orig, payload; !bytes.Equal(want, got) {
+			t.Errorf("want %q, got %q", want, got)
+		}
+
+		// The peer unmasks the original payload.
+		srv := newTestWS(&benchConn{stream: conn.buf.Bytes()}, false)
+		_, rd, err := srv.NextReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rd)
+		if want, got := (error)(nil), err; want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		if want, got := orig, got; !bytes.Equal(want, got) {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
+
+func BenchmarkMaskTransform(b *testing.B) {
+	m := mask{0x12, 0x34, 0x56, 0x78}
+	for _, size := range []int{16, 125, 1024, 64 * 1024} {
+		buf := make([]byte, size)
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				m.transform(buf, i)
+			}
+		})
+	}
+}
+
+func BenchmarkMaskKeys(b *testing.B) {
+	b.Run("batched", func(b *testing.B) {
+		var keys maskKeys
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := keys.next(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("crypto/rand", func(b *testing.B) {
+		var m mask
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := io.ReadFull(rand.Reader, m[:]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkClientWriteMessage(b *testing.B) {
+	for _, size := range []int{16, 1024, 64 * 1024} {
+		payload := make([]byte, size)
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			ws := newTestWS(&benchConn{stream: []byte{0}}, true)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := ws.WriteMessage(OpcodeBinary, payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrTextMessage is reported by the net.Conn returned by NetConn when a text message arrives.
+// The connection is then closed with close code 1003.
+var ErrTextMessage = errors.New("websocket: text message received by byte stream")
+
+// NetConn returns a net.Conn that tunnels a byte stream over ws.
+//
+// Reads span binary messages transparently and return io.EOF once the peer closes the connection normally.
+// Every write is sent as a single binary message.
+// Deadlines are those of the underlying connection. A read that times out while waiting for data
+// leaves the connection open, except in the middle of a compressed message.
+// Other timeouts drop the connection.
+// Closing the net.Conn performs the closing handshake.
+func NetConn(ws *WebSocket) net.Conn { return &netConn{ws: ws} }
+
+type netConn struct {
+	ws *WebSocket
+	mu sync.Mutex // guards rd
+	rd io.Reader  // the binary message being read, if any
+}
+
+func (c *netConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if c.rd == nil || !c.ws.mr.compressed {
+			if err := c.ws.waitData(); err != nil {
+				return 0, err
+			}
+		}
+		if c.rd == nil {
+			opcode, rd, err := c.ws.NextReader()
+			if err != nil {
+				return 0, streamError(err)
+			}
+			if opcode != OpcodeBinary {
+				c.ws.fail(1003, ErrTextMessage)
+				return 0, ErrTextMessage
+			}
+			c.rd = rd
+		}
+		n, err := c.rd.Read(b)
+		if err == io.EOF {
+			c.rd = nil
+			if n == 0 {
+				continue // empty messages don't end the stream
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// streamError converts a normal closure into io.EOF.
+func streamError(err error) error {
+	var cerr *CloseError
+	if errors.As(err, &cerr) && (cerr.Code == 1000 || cerr.Code == 1005) {
+		return io.EOF
+	}
+	return err
+}
+
+// waitData waits until the connection has data to read, returning an error only if it times out.
+// Unlike a timeout while reading a frame, it leaves the connection open.
+func (ws *WebSocket) waitData() error {
+	ws.lockRead()
+	defer ws.unlockRead()
+	if ws.fb.rd.Buffered() > 0 {
+		return nil
+	}
+	_, err := ws.fb.rd.Peek(1)
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return err
+	}
+	return nil // other errors are reported when reading frames
+}
+
+func (c *netConn) Write(b []byte) (int, error) {
+	if err := c.ws.WriteMessage(OpcodeBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *netConn) Close() error                       { return c.ws.Close() }
+func (c *netConn) LocalAddr() net.Addr                { return c.ws.LocalAddr() }
+func (c *netConn) RemoteAddr() net.Addr               { return c.ws.RemoteAddr() }
+func (c *netConn) SetDeadline(t time.Time) error      { return c.ws.SetDeadline(t) }
+func (c *netConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *netConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
@@ -0,0 +1,110 @@
+package websocket_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+func TestNetConn(t *testing.T) {
+	errc := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := UpgradeHTTP(w, r)
+		if err != nil {
+			errc <- err
+			return
+		}
+		conn := NetConn(ws)
+		defer conn.Close()
+		_, err = io.Copy(conn, conn) // echo
+		errc <- err
+	}))
+	defer srv.Close()
+
+	ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	conn := NetConn(ws)
+	if want, got := ws.LocalAddr().String(), conn.LocalAddr().String(); want != got {
+		t.Errorf("want %s, got %s", want, got)
+	}
+	if want, got := strings.TrimPrefix(srv.URL, "http://"), conn.RemoteAddr().String(); want != got {
+		t.Errorf("want %s, got %s", want, got)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Reads span many messages.
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	go func() {
+		for b := data; len(b) > 0; {
+			n := len(b)/3 + 1
+			conn.Write(b[:n])
+			b = b[n:]
+		}
+	}()
+	got := make([]byte, len(data))
+	_, err = io.ReadFull(conn, got)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if want, got := data, got; !bytes.Equal(want, got) {
+		t.Errorf("want %d bytes, got %d bytes", len(want), len(got))
+	}
+
+	// A normal closure ends the peer's stream with io.EOF.
+	if want, got := (error)(nil), conn.Close(); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := (error)(nil), <-errc; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestNetConnTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := UpgradeHTTP(w, r)
+		if err != nil {
+			return
+		}
+		conn := NetConn(ws)
+		defer conn.Close()
+		io.Copy(conn, conn) // echo
+	}))
+	defer srv.Close()
+
+	ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	conn := NetConn(ws)
+	defer conn.Close()
+
+	// Timing out while waiting for a message leaves the connection open.
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	b := make([]byte, 5)
+	_, err = conn.Read(b)
+	var nerr net.Error
+	if want, got := true, errors.As(err, &nerr) && nerr.Timeout(); want != got {
+		t.Fatalf("want %t, got %t (%v)", want, got, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadFull(conn, b)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if want, got := "hello", string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"sync"
+)
+
+// PreparedMessage caches the wire bytes of a message,
+// so that sending it to many connections encodes it only once.
+//
+// A variant is cached for each framing and compression setting it's sent with.
+// Connections using permessage-deflate with context takeover can't share compressed bytes,
+// so they are sent the uncompressed variant.
+type PreparedMessage struct {
+	opcode  uint8
+	payload []byte
+
+	mu     sync.Mutex // guards frames
+	frames map[prepareKey]*preparedFrame
+}
+
+// prepareKey identifies a variant of a prepared message.
+type prepareKey struct {
+	client     bool
+	compressed bool
+	level      int
+}
+
+// preparedFrame is a variant of a prepared message.
+// Client frames need a new masking key every time, so only server frames are cached whole.
+type preparedFrame struct {
+	b0      byte
+	payload []byte
+	wire    []byte // header and payload of server frames
+}
+
+// NewPreparedMessage prepares a message with the given opcode,
+// which must be either OpcodeText or OpcodeBinary.
+func NewPreparedMessage(opcode uint8, b []byte) (*PreparedMessage, error) {
+	if opcode != OpcodeText && opcode != OpcodeBinary {
+		return nil, ErrInvalidOpcode
+	}
+	return &PreparedMessage{
+		opcode:  opcode,
+		payload: append([]byte(nil), b...),
+		frames:  make(map[prepareKey]*preparedFrame),
+	}, nil
+}
+
+// frame returns the variant for a key, encoding it the first time it's requested.
+func (pm *PreparedMessage) frame(key prepareKey) (*preparedFrame, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pf, ok := pm.frames[key]; ok {
+		return pf, nil
+	}
+	pf := &preparedFrame{b0: leftBit | pm.opcode, payload: pm.payload}
+	if key.compressed {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, key.level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fw.Write(pm.payload); err != nil {
+			return nil, err
+		}
+		if err = fw.Flush(); err != nil {
+			return nil, err
+		}
+		pf.b0 |= rsv1Bit
+		pf.payload = bytes.TrimSuffix(buf.Bytes(), flushMarker)
+	}
+	if !key.client {
+		var hdr [maxHeaderSize]byte
+		n := encodeHeader(hdr[:], pf.b0, len(pf.payload), false)
+		pf.wire = append(hdr[:n:n], pf.payload...)
+	}
+	pm.frames[key] = pf
+	return pf, nil
+}
+
+// WritePreparedMessage sends a prepared message as a single frame.
+//
+// It's safe to call WritePreparedMessage from many goroutines at once.
+func (ws *WebSocket) WritePreparedMessage(pm *PreparedMessage) error {
+	return ws.writer.writePrepared(pm)
+}
+
+func (w *writer) writePrepared(pm *PreparedMessage) error {
+	key := prepareKey{client: w.client}
+	if d := w.deflater; d != nil && !d.takeover {
+		key.compressed = true
+		key.level = d.level
+	}
+	pf, err := pm.frame(key)
+	if err != nil {
+		return err
+	}
+
+	w.msgMu.Lock()
+	defer w.msgMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.client {
+		if w.err != nil {
+			return w.err
+		}
+		if _, err = w.wr.Write(pf.wire); err != nil {
+			return w.setErr(err)
+		}
+		return w.flush()
+	}
+
+	// Mask a copy of the payload, since it's shared with other connections.
+	m, err := w.writeHeader(pf.b0, len(pf.payload))
+	if err != nil {
+		return err
+	}
+	w.getMessageBuffer()
+	defer w.putMessageBuffer()
+	for pos := 0; pos < len(pf.payload); {
+		chunk := w.buf[:copy(w.buf[:cap(w.buf)], pf.payload[pos:])]
+		pos = m.transform(chunk, pos)
+		if _, err = w.wr.Write(chunk); err != nil {
+			return w.setErr(err)
+		}
+	}
+	return w.flush()
+}
@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestPreparedMessage(t *testing.T) {
+	payload := bytes.Repeat([]byte("prepared message "), 1000)
+	pm, err := NewPreparedMessage(OpcodeText, payload)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	testCases := []struct {
+		client     bool
+		compressed bool
+		takeover   bool
+	}{
+		{client: false},
+		{client: true},
+		{client: false, compressed: true},
+		{client: true, compressed: true},
+		{client: false, compressed: true, takeover: true},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			conn := &captureConn{}
+			ws := newTestWS(conn, tc.client)
+			dp := &deflateParams{
+				serverNoContextTakeover: !tc.takeover,
+				clientNoContextTakeover: !tc.takeover,
+			}
+			if tc.compressed {
+				ws.enableCompression(dp, &CompressionOptions{})
+			}
+			// Sending it twice uses the cached variant.
+			for i := 0; i < 2; i++ {
+				if err := ws.WritePreparedMessage(pm); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			peer := newTestWS(&benchConn{stream: conn.buf.Bytes()}, !tc.client)
+			if tc.compressed {
+				peer.enableCompression(dp, &CompressionOptions{})
+			}
+			for i := 0; i < 2; i++ {
+				opcode, rd, err := peer.NextReader()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := io.ReadAll(rd)
+				if want, got := (error)(nil), err; want != got {
+					t.Fatalf("want %v, got %v", want, got)
+				}
+				if want, got := uint8(OpcodeText), opcode; want != got {
+					t.Errorf("want %d, got %d", want, got)
+				}
+				if want, got := payload, got; !bytes.Equal(want, got) {
+					t.Errorf("want %d bytes, got %d bytes", len(want), len(got))
+				}
+			}
+			// Connections with context takeover get uncompressed frames.
+			compressed := conn.buf.Len() < 2*len(payload)
+			if want, got := tc.compressed && !tc.takeover, compressed; want != got {
+				t.Errorf("want %t, got %t", want, got)
+			}
+		})
+	}
+	if want, got := 4, len(pm.frames); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+}
+
+func BenchmarkWritePreparedMessage(b *testing.B) {
+	payload := bytes.Repeat([]byte("broadcast "), 100)
+	for _, compressed := range []bool{false, true} {
+		dp := &deflateParams{serverNoContextTakeover: true}
+		newServer := func() *WebSocket {
+			ws := newTestWS(&benchConn{stream: []byte{0}}, false)
+			if compressed {
+				ws.enableCompression(dp, &CompressionOptions{})
+			}
+			return ws
+		}
+		b.Run(fmt.Sprintf("prepared,compressed=%t", compressed), func(b *testing.B) {
+			ws := newServer()
+			pm, _ := NewPreparedMessage(OpcodeText, payload)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := ws.WritePreparedMessage(pm); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("message,compressed=%t", compressed), func(b *testing.B) {
+			ws := newServer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := ws.WriteMessage(OpcodeText, payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
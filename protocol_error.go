@@ -0,0 +1,41 @@
+package websocket
+
+import "fmt"
+
+// ProtocolError is reported by Err when the peer violates the WebSocket Protocol.
+// The connection is then closed with Code, as required by RFC 6455.
+//
+// Err is one of the package's sentinel errors, so it may be matched with errors.Is.
+type ProtocolError struct {
+	Code uint16
+	Err  error
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%v (close code %d)", e.Err, e.Code)
+}
+
+func (e *ProtocolError) Unwrap() error { return e.Err }
+
+// protocolCloseCode returns the close code for a read error, or zero if it isn't a protocol error.
+func protocolCloseCode(err error) uint16 {
+	switch err {
+	case ErrFragmentedControlFrame,
+		ErrInvalidOpcode,
+		ErrInvalidContinuationOpcode,
+		ErrHeadlessContinuation,
+		ErrUnmasked,
+		ErrMasked,
+		ErrLargeControlFrame,
+		ErrUnnegotiatedRSV,
+		ErrInvalidClosePayload,
+		ErrIllegalLength,
+		ErrInvalidCloseCode:
+		return 1002
+	case ErrInvalidUTF8:
+		return 1007
+	case ErrFrameTooBig, ErrMessageTooBig, ErrTooManyFragments:
+		return 1009
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package websocket_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+func TestProtocolError(t *testing.T) {
+	testCases := []struct {
+		frame []byte
+		err   error
+		cc    uint16
+	}{
+		{frame: []byte{0x81, 0x00}, err: ErrUnmasked, cc: 1002},
+		{frame: []byte{0x83, 0x80, 0, 0, 0, 0}, err: ErrInvalidOpcode, cc: 1002},
+		{frame: []byte{0x89, 0xfe, 0x00, 0x7e, 0, 0, 0, 0}, err: ErrLargeControlFrame, cc: 1002},
+		{frame: []byte{0x81, 0x81, 0, 0, 0, 0, 0xff}, err: ErrInvalidUTF8, cc: 1007},
+		// The final fragment never arrives, so invalid text must fail right away.
+		{frame: []byte{0x01, 0x82, 0, 0, 0, 0, 0xf4, 0x90}, err: ErrInvalidUTF8, cc: 1007},
+		{frame: []byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xed}, err: ErrInvalidCloseCode, cc: 1002},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			errc := make(chan error, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ws, err := UpgradeHTTP(w, r)
+				if err != nil {
+					errc <- err
+					return
+				}
+				for ws.Next() {
+				}
+				errc <- ws.Err()
+			}))
+			defer srv.Close()
+
+			// Keep the raw connection in order to send invalid frames.
+			var conn net.Conn
+			d := Dialer{NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var (
+					nd  net.Dialer
+					err error
+				)
+				conn, err = nd.DialContext(ctx, network, addr)
+				return conn, err
+			}}
+			ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			defer ws.Close()
+			if _, err = conn.Write(tc.frame); err != nil {
+				t.Fatal(err)
+			}
+
+			err = <-errc
+			var perr *ProtocolError
+			if want, got := true, errors.As(err, &perr) && errors.Is(err, tc.err); want != got {
+				t.Fatalf("want %t, got %t (%v)", want, got, err)
+			}
+			if want, got := tc.cc, perr.Code; want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+			// The peer receives the close code before the connection is dropped.
+			ws.Next()
+			var cerr *CloseError
+			if want, got := true, errors.As(ws.Err(), &cerr) && cerr.Code == tc.cc; want != got {
+				t.Errorf("want %t, got %t (%v)", want, got, ws.Err())
+			}
+		})
+	}
+}
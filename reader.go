@@ -0,0 +1,58 @@
+package websocket
+
+import "io"
+
+// frameReader reads the payload of a message frame by frame.
+type frameReader struct {
+	ws *WebSocket
+}
+
+func (fr *frameReader) Read(b []byte) (int, error) {
+	ws, fb := fr.ws, fr.ws.fb
+	ws.lockRead()
+	defer ws.unlockRead()
+	if fb.err != nil {
+		return 0, fb.err
+	}
+	for fb.remaining == 0 {
+		if fb.first { // the final frame was read
+			return 0, io.EOF
+		}
+		if _, err := ws.nextHeader(); err != nil {
+			if err == io.EOF {
+				// The connection was closed in the middle of the message.
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	return fb.readPayload(b)
+}
+
+// messageReader is the reader returned by NextReader.
+type messageReader struct {
+	ws         *WebSocket
+	rd         io.Reader
+	size       int64
+	err        error
+	compressed bool
+}
+
+func (mr *messageReader) Read(b []byte) (int, error) {
+	if mr.err != nil {
+		return 0, mr.err
+	}
+	n, err := mr.rd.Read(b)
+	// Compressed messages can only have their real size checked while reading.
+	mr.size += int64(n)
+	if limit := mr.ws.fb.limits.MessageSize; limit > 0 && mr.size > limit {
+		n, err = 0, ErrMessageTooBig
+	}
+	if err != nil {
+		if err != io.EOF {
+			err = mr.ws.readError(err)
+		}
+		mr.err = err
+	}
+	return n, err
+}
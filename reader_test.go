@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// benchConn is a connection whose peer keeps sending the same frames.
+type benchConn struct {
+	net.Conn
+	stream []byte
+	pos    int
+}
+
+func (c *benchConn) Read(b []byte) (int, error) {
+	n := copy(b, c.stream[c.pos:])
+	c.pos = (c.pos + n) % len(c.stream)
+	return n, nil
+}
+
+func (c *benchConn) Write(b []byte) (int, error) { return len(b), nil }
+
+// newTestWS returns a WebSocket with default buffer sizes.
+func newTestWS(conn net.Conn, client bool) *WebSocket {
+	return newWS(conn, client, newReader(conn, nil, 0), newWriter(conn, nil, 0))
+}
+
+// clientFrame encodes a masked frame as sent by a client.
+func clientFrame(b0 byte, payload []byte) []byte {
+	var hdr []byte
+	switch size := len(payload); {
+	case size <= 125:
+		hdr = []byte{b0, leftBit | byte(size)}
+	case size <= 0xffff:
+		hdr = []byte{b0, leftBit | 126, 0, 0}
+		binary.BigEndian.PutUint16(hdr[2:], uint16(size))
+	default:
+		hdr = []byte{b0, leftBit | 127, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(hdr[2:], uint64(size))
+	}
+	m := mask{0x12, 0x34, 0x56, 0x78}
+	masked := append([]byte(nil), payload...)
+	m.transform(masked, 0)
+	return append(append(hdr, m[:]...), masked...)
+}
+
+func BenchmarkNext(b *testing.B) {
+	for _, size := range []int{16, 1024, 64 * 1024} {
+		for _, pooled := range []bool{false, true} {
+			b.Run(fmt.Sprintf("size=%d,pool=%t", size, pooled), func(b *testing.B) {
+				payload := bytes.Repeat([]byte{'a'}, size)
+				// Send the message in two fragments with a ping between them.
+				var stream []byte
+				stream = append(stream, clientFrame(OpcodeText, payload[:size/2])...)
+				stream = append(stream, clientFrame(leftBit|opcodePing, []byte("ping"))...)
+				stream = append(stream, clientFrame(leftBit|opcodeContinuation, payload[size/2:])...)
+
+				ws := newTestWS(&benchConn{stream: stream}, false)
+				if pooled {
+					ws.pool = &sync.Pool{}
+				}
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if !ws.Next() {
+						b.Fatal(ws.Err())
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkNextReader(b *testing.B) {
+	payload := bytes.Repeat([]byte{'a'}, 1024)
+	ws := newTestWS(&benchConn{stream: clientFrame(leftBit|OpcodeBinary, payload)}, false)
+	buf := make([]byte, len(payload))
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, rd, err := ws.NextReader()
+		if err != nil {
+			b.Fatal(err)
+		}
+		for n := 0; err == nil; {
+			n, err = rd.Read(buf)
+			_ = n
+		}
+	}
+}
+
+func TestNextReaderFinalBlock(t *testing.T) {
+	// The compressed payload ends with a final deflate block,
+	// followed by an empty frame that ends the message (RFC 7692, section 7.2.3.4).
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestSpeed)
+	fw.Write([]byte("hello"))
+	fw.Close()
+	var stream []byte
+	stream = append(stream, clientFrame(rsv1Bit|OpcodeText, compressed.Bytes())...)
+	stream = append(stream, clientFrame(leftBit|opcodeContinuation, nil)...)
+	stream = append(stream, clientFrame(leftBit|OpcodeText, []byte("next"))...)
+
+	ws := newTestWS(&benchConn{stream: stream}, false)
+	ws.fb.inflater = &inflater{}
+	for _, want := range []string{"hello", "next"} {
+		opcode, rd, err := ws.NextReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := uint8(OpcodeText), opcode; want != got {
+			t.Errorf("want %#x, got %#x", want, got)
+		}
+		// Only read until the payload ends.
+		payload, err := io.ReadAll(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(payload); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Registry keeps track of open connections so that they can be closed
+// gracefully when the server shuts down, since hijacked connections
+// are ignored by http.Server.Shutdown.
+// The zero value is ready to use.
+type Registry struct {
+	mu       sync.Mutex
+	conns    map[*WebSocket]struct{}
+	shutdown bool
+	done     chan struct{}
+}
+
+// Add starts tracking ws until it's closed.
+// Connections added after shutdown has started are closed right away with close code 1001.
+func (r *Registry) Add(ws *WebSocket) {
+	r.mu.Lock()
+	if r.shutdown {
+		r.mu.Unlock()
+		go ws.CloseWithReason(1001, "going away")
+		return
+	}
+	if r.conns == nil {
+		r.conns = make(map[*WebSocket]struct{})
+	}
+	r.conns[ws] = struct{}{}
+	r.mu.Unlock()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.state == stateClosed {
+		r.remove(ws)
+		return
+	}
+	ws.onClosed = append(ws.onClosed, func() { r.remove(ws) })
+}
+
+func (r *Registry) remove(ws *WebSocket) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, ws)
+}
+
+// Len returns the number of open connections.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// Shutdown sends close code 1001 to every open connection
+// and waits for their closing handshakes to finish.
+// If ctx is done first, the remaining connections are dropped and its error is returned.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.shutdown = true
+	if r.done == nil {
+		r.done = make(chan struct{})
+	}
+	conns := make([]*WebSocket, 0, len(r.conns))
+	for ws := range r.conns {
+		conns = append(conns, ws)
+	}
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(conns))
+	for _, ws := range conns {
+		go func(ws *WebSocket) {
+			defer wg.Done()
+			ws.CloseWithReason(1001, "going away")
+		}(ws)
+	}
+	closed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(closed)
+	}()
+
+	var err error
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		for _, ws := range conns {
+			ws.drop()
+		}
+		<-closed
+		err = ctx.Err()
+	}
+	r.mu.Lock()
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	r.mu.Unlock()
+	return err
+}
+
+// Done returns a channel that's closed once a shutdown finishes.
+func (r *Registry) Done() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done == nil {
+		r.done = make(chan struct{})
+	}
+	return r.done
+}
+
+// RegisterOnShutdown makes srv shut down the registry's connections when srv.Shutdown is called,
+// waiting up to timeout for their closing handshakes.
+//
+// Since srv.Shutdown doesn't wait for them, use Done to know when they're closed.
+func (r *Registry) RegisterOnShutdown(srv *http.Server, timeout time.Duration) {
+	srv.RegisterOnShutdown(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		r.Shutdown(ctx)
+	})
+}
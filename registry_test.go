@@ -0,0 +1,121 @@
+package websocket_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+func TestRegistry(t *testing.T) {
+	testCases := []struct {
+		clientReads bool
+		err         error
+	}{
+		{clientReads: true, err: nil},
+		// Clients that don't answer the close frame are dropped.
+		{clientReads: false, err: context.DeadlineExceeded},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			var reg Registry
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				u := Upgrader{Registry: &reg}
+				ws, err := u.Upgrade(w, r)
+				if err != nil {
+					return
+				}
+				for ws.Next() {
+				}
+			}))
+			defer srv.Close()
+
+			clients := make([]*WebSocket, 3)
+			for i := range clients {
+				ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+				if want, got := (error)(nil), err; want != got {
+					t.Fatalf("want %v, got %v", want, got)
+				}
+				defer ws.Close()
+				clients[i] = ws
+			}
+			for reg.Len() < len(clients) {
+				time.Sleep(time.Millisecond)
+			}
+			errc := make(chan error, len(clients))
+			if tc.clientReads {
+				for _, ws := range clients {
+					go func(ws *WebSocket) {
+						for ws.Next() {
+						}
+						errc <- ws.Err()
+					}(ws)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if want, got := tc.err, reg.Shutdown(ctx); want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+			if want, got := 0, reg.Len(); want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+			if !tc.clientReads {
+				return
+			}
+			for range clients {
+				var cerr *CloseError
+				err := <-errc
+				if want, got := true, errors.As(err, &cerr) && cerr.Code == 1001; want != got {
+					t.Errorf("want %t, got %t (%v)", want, got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryOnShutdown(t *testing.T) {
+	var reg Registry
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := Upgrader{Registry: &reg}
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		for ws.Next() {
+		}
+	}))
+	reg.RegisterOnShutdown(srv.Config, time.Second)
+	srv.Start()
+	defer srv.Close()
+
+	ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+	for reg.Len() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		for ws.Next() {
+		}
+	}()
+
+	srv.Config.Shutdown(context.Background())
+	select {
+	case <-reg.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("registry not shut down")
+	}
+	var cerr *CloseError
+	if want, got := true, errors.As(ws.Err(), &cerr) && cerr.Code == 1001; want != got {
+		t.Errorf("want %t, got %t (%v)", want, got, ws.Err())
+	}
+}
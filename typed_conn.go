@@ -0,0 +1,29 @@
+package websocket
+
+// TypedConn sends and receives values of type T over a WebSocket,
+// encoding each one as a message using a Codec.
+type TypedConn[T any] struct {
+	ws    *WebSocket
+	codec Codec
+}
+
+// NewTypedConn returns a TypedConn over ws using c, or JSON if c is nil.
+func NewTypedConn[T any](ws *WebSocket, c Codec) *TypedConn[T] {
+	if c == nil {
+		c = JSON
+	}
+	return &TypedConn[T]{ws: ws, codec: c}
+}
+
+// Send sends v as a single message.
+func (tc *TypedConn[T]) Send(v T) error { return tc.ws.WriteValue(tc.codec, v) }
+
+// Receive reads the next message as a value of type T.
+func (tc *TypedConn[T]) Receive() (T, error) {
+	var v T
+	err := tc.ws.ReadValue(tc.codec, &v)
+	return v, err
+}
+
+// WebSocket returns the underlying WebSocket.
+func (tc *TypedConn[T]) WebSocket() *WebSocket { return tc.ws }
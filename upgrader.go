@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"bufio"
+	"net/http"
+	"time"
+
+	"github.com/gbrlsnchs/websocket/internal"
+)
+
+// Upgrader configures how HTTP requests are upgraded to the WebSocket Protocol.
+// The zero value is ready to use.
+type Upgrader struct {
+	// ReadBufferSize and WriteBufferSize set the size of I/O buffers.
+	// Zero means 4096 bytes.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// Header is added to the 101 response, e.g. for setting cookies.
+	Header http.Header
+	// Check is called before upgrading the request, e.g. for authenticating it.
+	// Returning an error rejects the request with the returned HTTP status,
+	// or with 403 Forbidden if the status isn't a client or server error, such as zero.
+	Check func(r *http.Request) (int, error)
+	// Error replies to rejected requests with the HTTP status required by RFC 6455,
+	// or the one returned by Check. If nil, the status text is replied.
+	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
+	// Compression enables permessage-deflate when the client offers it.
+	Compression *CompressionOptions
+	// Subprotocols lists the supported application subprotocols in order of preference.
+	Subprotocols []string
+	// ReadLimits bounds the size of incoming frames and messages.
+	ReadLimits ReadLimits
+	// KeepAlive sends pings periodically to detect dead connections.
+	KeepAlive KeepAlive
+	// CloseTimeout limits how long Close waits for the peer's close frame.
+	// Zero means 5 seconds.
+	CloseTimeout time.Duration
+	// BufferPool provides the buffers that messages read by Next are stored in.
+	// If nil, every message is read into a new buffer.
+	BufferPool BufferPool
+	// Registry tracks upgraded connections so that they can be closed on shutdown.
+	Registry *Registry
+}
+
+// Upgrade switches the protocol from HTTP to the WebSocket Protocol.
+//
+// HTTP/2 requests are upgraded with extended CONNECT (RFC 8441), as long as the server enables it.
+// The connection is then carried by the request's stream instead of a hijacked connection,
+// so the handler must not return before the WebSocket is done.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	if u.Check != nil {
+		if status, err := u.Check(r); err != nil {
+			if status < 400 || status > 599 {
+				status = http.StatusForbidden
+			}
+			u.reject(w, r, status, err)
+			return nil, err
+		}
+	}
+
+	hdr := make(http.Header, len(u.Header)+2)
+	for k, v := range u.Header {
+		hdr[k] = v
+	}
+	var dp *deflateParams
+	if u.Compression != nil {
+		// Malformed offers are declined rather than failing the handshake.
+		if offers, err := internal.ParseExtensions(r.Header); err == nil {
+			if dp = u.Compression.accept(offers); dp != nil {
+				hdr.Set("Sec-WebSocket-Extensions", dp.extension().String())
+			}
+		}
+	}
+
+	protocol := internal.SelectSubprotocol(r, u.Subprotocols)
+	if protocol != "" {
+		hdr.Set("Sec-WebSocket-Protocol", protocol)
+	}
+
+	conn, bufrw, err := internal.Handshake(w, r, hdr, func(status int, err error) {
+		u.reject(w, r, status, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Reuse the hijacked buffers, which may already hold frames sent by the client.
+	var (
+		br *bufio.Reader
+		bw *bufio.Writer
+	)
+	if bufrw != nil {
+		br, bw = bufrw.Reader, bufrw.Writer
+	}
+	ws := newWS(conn, false,
+		newReader(conn, br, u.ReadBufferSize),
+		newWriter(conn, bw, u.WriteBufferSize))
+	ws.SetReadLimits(u.ReadLimits)
+	ws.SetKeepAlive(u.KeepAlive)
+	ws.closeTimeout = u.CloseTimeout
+	ws.pool = u.BufferPool
+	ws.subprotocol = protocol
+	ws.header = w.Header().Clone()
+	if dp != nil {
+		ws.enableCompression(dp, u.Compression)
+	}
+	if u.Registry != nil {
+		u.Registry.Add(ws)
+	}
+	return ws, nil
+}
+
+func (u *Upgrader) reject(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if u.Error != nil {
+		u.Error(w, r, status, err)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,218 @@
+package websocket_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+func TestUpgrader(t *testing.T) {
+	u := Upgrader{
+		Header: http.Header{"Set-Cookie": {"session=foo"}},
+		Check: func(r *http.Request) (int, error) {
+			if r.Header.Get("Authorization") == "" {
+				return http.StatusUnauthorized, errors.New("missing credentials")
+			}
+			return 0, nil
+		},
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			w.WriteHeader(status)
+			fmt.Fprint(w, reason)
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		ws.Close()
+	}))
+	defer srv.Close()
+
+	testCases := []struct {
+		authorization string
+		secWSVersion  string
+		status        int
+		header        http.Header
+		body          string
+	}{
+		{
+			secWSVersion: "13",
+			status:       http.StatusUnauthorized,
+			body:         "missing credentials",
+		},
+		{
+			authorization: "Bearer foo",
+			secWSVersion:  "8",
+			status:        http.StatusUpgradeRequired,
+			header:        http.Header{"Sec-Websocket-Version": {"13"}},
+			body:          "websocket: missing Sec-WebSocket-Version header",
+		},
+		{
+			authorization: "Bearer foo",
+			secWSVersion:  "13",
+			status:        http.StatusSwitchingProtocols,
+			header:        http.Header{"Set-Cookie": {"session=foo"}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			r.Header.Set("Authorization", tc.authorization)
+			r.Header.Set("Upgrade", "websocket")
+			r.Header.Set("Connection", "upgrade")
+			r.Header.Set("Sec-WebSocket-Version", tc.secWSVersion)
+			r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+			var c http.Client
+			rr, err := c.Do(r)
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			defer rr.Body.Close()
+			if want, got := tc.status, rr.StatusCode; want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+			for k := range tc.header {
+				if want, got := tc.header.Get(k), rr.Header.Get(k); want != got {
+					t.Errorf("want %q, got %q", want, got)
+				}
+			}
+			if tc.status == http.StatusSwitchingProtocols {
+				return
+			}
+			body, _ := io.ReadAll(rr.Body)
+			if want, got := tc.body, string(body); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestUpgraderCheckStatus(t *testing.T) {
+	testCases := []struct {
+		check int
+		want  int
+	}{
+		{check: 0, want: http.StatusForbidden},
+		{check: http.StatusOK, want: http.StatusForbidden},
+		{check: http.StatusSwitchingProtocols, want: http.StatusForbidden},
+		{check: http.StatusFound, want: http.StatusForbidden},
+		{check: http.StatusTooManyRequests, want: http.StatusTooManyRequests},
+		{check: http.StatusServiceUnavailable, want: http.StatusServiceUnavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.check), func(t *testing.T) {
+			u := Upgrader{
+				Check: func(r *http.Request) (int, error) { return tc.check, errors.New("banned") },
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if ws, err := u.Upgrade(w, r); err == nil {
+					ws.Close()
+				}
+			}))
+			defer srv.Close()
+
+			_, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+			var herr *HandshakeError
+			if want, got := true, errors.As(err, &herr); want != got {
+				t.Fatalf("want %t, got %t (%v)", want, got, err)
+			}
+			if want, got := tc.want, herr.Response.StatusCode; want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestUpgraderBufferedFrames(t *testing.T) {
+	testCases := []struct {
+		size int
+		loop bool
+	}{
+		{size: 0},
+		{size: 8192},
+		{size: 0, loop: true},
+		{size: 8192, loop: true},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d/%t", tc.size, tc.loop), func(t *testing.T) {
+			if tc.loop && runtime.GOOS != "linux" {
+				t.Skip("event loop not supported")
+			}
+			msgs := make(chan string, 1)
+			l := EventLoop{
+				Handler: func(ws *WebSocket, opcode uint8, payload []byte) {
+					msgs <- string(payload)
+				},
+			}
+			defer l.Close()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				u := Upgrader{ReadBufferSize: tc.size}
+				ws, err := u.Upgrade(w, r)
+				if err != nil {
+					close(msgs)
+					return
+				}
+				if tc.loop {
+					if err = l.Add(ws); err != nil {
+						t.Error(err)
+					}
+					return
+				}
+				defer ws.Close()
+				if ws.Next() {
+					payload, _ := ws.Message()
+					msgs <- string(payload)
+				}
+				close(msgs)
+			}))
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			// The first frame is sent along with the handshake, before the response arrives.
+			req := "GET / HTTP/1.1\r\n" +
+				"Host: " + conn.RemoteAddr().String() + "\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Sec-WebSocket-Version: 13\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+			frame := []byte{0x81, 0x85, 0, 0, 0, 0, 'h', 'e', 'l', 'l', 'o'}
+			if _, err = conn.Write(append([]byte(req), frame...)); err != nil {
+				t.Fatal(err)
+			}
+			rr, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := http.StatusSwitchingProtocols, rr.StatusCode; want != got {
+				t.Fatalf("want %d, got %d", want, got)
+			}
+			select {
+			case msg := <-msgs:
+				if want, got := "hello", msg; want != got {
+					t.Errorf("want %q, got %q", want, got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("message not received")
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import "io"
+
+const (
+	utf8Accept = 0
+	utf8Reject = 12
+)
+
+// utf8Classes maps every byte to its character class in utf8Transitions.
+var utf8Classes = func() (classes [256]uint8) {
+	for b := 0x80; b < 0x100; b++ {
+		switch {
+		case b <= 0x8f:
+			classes[b] = 1
+		case b <= 0x9f:
+			classes[b] = 9
+		case b <= 0xbf:
+			classes[b] = 7
+		case b <= 0xc1:
+			classes[b] = 8
+		case b <= 0xdf:
+			classes[b] = 2
+		case b == 0xe0:
+			classes[b] = 10
+		case b == 0xed:
+			classes[b] = 4
+		case b <= 0xef:
+			classes[b] = 3
+		case b == 0xf0:
+			classes[b] = 11
+		case b <= 0xf3:
+			classes[b] = 6
+		case b == 0xf4:
+			classes[b] = 5
+		default:
+			classes[b] = 8
+		}
+	}
+	return classes
+}()
+
+// utf8Transitions is the UTF-8 decoding automaton by Björn Höhrmann,
+// indexed by the current state plus the class of the next byte.
+// It rejects a sequence as soon as a byte can't continue it,
+// e.g. an overlong encoding, a surrogate or a code point above U+10FFFF.
+var utf8Transitions = [108]uint8{
+	0, 12, 24, 36, 60, 96, 84, 12, 12, 12, 48, 72,
+	12, 12, 12, 12, 12, 12, 12, 12, 12, 12, 12, 12,
+	12, 0, 12, 12, 12, 12, 12, 0, 12, 0, 12, 12,
+	12, 24, 12, 12, 12, 12, 12, 24, 12, 24, 12, 12,
+	12, 12, 12, 12, 12, 12, 12, 24, 12, 12, 12, 12,
+	12, 24, 12, 12, 12, 12, 12, 12, 12, 24, 12, 12,
+	12, 12, 12, 12, 12, 12, 12, 36, 12, 36, 12, 12,
+	12, 36, 12, 12, 12, 12, 12, 36, 12, 36, 12, 12,
+	12, 36, 12, 12, 12, 12, 12, 12, 12, 12, 12, 12,
+}
+
+// utf8Reader validates text as it is read, failing on the first invalid byte
+// even if the code point it belongs to continues in a later read.
+type utf8Reader struct {
+	rd    io.Reader
+	state uint8
+}
+
+func (u *utf8Reader) Read(b []byte) (int, error) {
+	n, err := u.rd.Read(b)
+	if !u.valid(b[:n]) || err == io.EOF && u.state != utf8Accept {
+		return 0, ErrInvalidUTF8
+	}
+	return n, err
+}
+
+// valid reports whether p continues a valid UTF-8 sequence.
+func (u *utf8Reader) valid(p []byte) bool {
+	state := u.state
+	for i := 0; i < len(p); i++ {
+		if state == utf8Accept {
+			// Skip ASCII quickly.
+			for i < len(p) && p[i] < 0x80 {
+				i++
+			}
+			if i == len(p) {
+				break
+			}
+		}
+		if state = utf8Transitions[state+utf8Classes[p[i]]]; state == utf8Reject {
+			return false
+		}
+	}
+	u.state = state
+	return true
+}
@@ -0,0 +1,47 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestUTF8Reader(t *testing.T) {
+	errMore := errors.New("read past invalid UTF-8")
+
+	testCases := []struct {
+		text string
+		more bool
+		err  error
+	}{
+		{text: "", err: nil},
+		{text: "Hello, WebSocket!", err: nil},
+		{text: "κόσμε", err: nil},
+		{text: "\xf0\x9f\x98\x80 emoji", err: nil},
+		{text: "κόσμε \xed\xa0\x80", err: ErrInvalidUTF8},
+		{text: "truncated \xe2\x82", err: ErrInvalidUTF8},
+		{text: "\xff", err: ErrInvalidUTF8},
+		{text: "\xc0\xaf overlong", err: ErrInvalidUTF8},
+		{text: "\xf4\x8f\xbf\xbf", err: nil},
+		// Invalid prefixes fail before the rest of the code point is read.
+		{text: "κόσμε \xf4\x90", more: true, err: ErrInvalidUTF8},
+		{text: "\xed\xa0", more: true, err: ErrInvalidUTF8},
+		{text: "\xe0\x80", more: true, err: ErrInvalidUTF8},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			// Read one byte at a time so that every code point is split.
+			var src io.Reader = bytes.NewReader([]byte(tc.text))
+			if tc.more {
+				src = io.MultiReader(src, iotest.ErrReader(errMore))
+			}
+			rd := &utf8Reader{rd: iotest.OneByteReader(src)}
+			_, err := io.Copy(io.Discard, rd)
+			if want, got := tc.err, err; want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+		})
+	}
+}
@@ -2,22 +2,26 @@ package websocket
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
 	"errors"
 	"io"
 	"net"
 	"net/http"
-	"unicode/utf8"
-
-	"github.com/gbrlsnchs/websocket/internal"
+	"sync"
+	"time"
 )
 
 var (
-	errInvalidCloseCode = errors.New("websocket: invalid close code")
-	errInvalidUTF8      = errors.New("websocket: payload contains invalid UTF-8 content")
+	// ErrInvalidCloseCode is reported when a close code is reserved or out of range.
+	ErrInvalidCloseCode = errors.New("websocket: invalid close code")
+	// ErrInvalidUTF8 is reported when a text message or close reason is not valid UTF-8.
+	ErrInvalidUTF8 = errors.New("websocket: payload contains invalid UTF-8 content")
 )
 
-const defaultRWSize = 4096
+const (
+	defaultRWSize = 4096
+	minRWSize     = 256
+)
 const (
 	stateOpen = iota
 	stateClosing
@@ -29,135 +33,420 @@ const (
 type WebSocket struct {
 	*writer
 
-	fb   *frameBuffer
-	conn io.Closer
+	fb       *frameBuffer
+	conn     net.Conn
+	readLock chan struct{} // held while frames are read
 
+	mu    sync.Mutex // guards state, cc, err and keepalive data
 	state int
 	cc    uint16
+	err   error
+	done  chan struct{}
+
+	closeTimeout time.Duration
+	onClosed     []func() // called once the connection is closed, with mu held
+	polled       bool     // whether frames are read by an EventLoop
+	serving      bool     // whether an EventLoop worker is reading frames
+
+	pingHandler   func([]byte) error
+	pongHandler   func([]byte) error
+	stopKeepAlive chan struct{}
+	pingPayload   [8]byte
+	pingSent      time.Time
+	pongTimer     *time.Timer
+	rtt           time.Duration
 
 	opcode  uint8
 	payload []byte
-	err     error
+	readPos int // position of Read in payload
+	pool    BufferPool
+	buf     *bytes.Buffer // holds payload when it comes from pool
+
+	subprotocol string
+	header      http.Header    // of the opening handshake's response
+	rd          *messageReader // the current message reader, if any
+	fr          frameReader
+	ur          utf8Reader
+	mr          messageReader
 }
 
-func newWS(conn net.Conn, client bool) *WebSocket {
-	return &WebSocket{
+func newWS(conn net.Conn, client bool, rd *bufio.Reader, wr *bufio.Writer) *WebSocket {
+	ws := &WebSocket{
 		fb: &frameBuffer{
-			rd:     bufio.NewReaderSize(conn, defaultRWSize),
+			rd:     rd,
 			first:  true,
 			client: client,
 		},
 		writer: &writer{
-			wr:     bufio.NewWriterSize(conn, defaultRWSize),
+			wr:     wr,
 			opcode: OpcodeText,
 			client: client,
 		},
-		conn: conn,
+		conn:     conn,
+		readLock: make(chan struct{}, 1),
+		done:     make(chan struct{}),
 	}
+	ws.writer.onError = ws.writeError
+	ws.fr.ws = ws
+	return ws
 }
 
-// UpgradeHTTP switches the protocol from HTTP to the WebSocket Protocol.
-func UpgradeHTTP(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
-	conn, err := internal.Handshake(w, r)
-	if err != nil {
-		return nil, err
+// newReader returns a reader for conn, reusing rd if it's at least as large as size.
+// Otherwise, bytes already buffered by rd are moved to the new reader.
+func newReader(conn net.Conn, rd *bufio.Reader, size int) *bufio.Reader {
+	size = bufferSize(size)
+	switch {
+	case rd == nil:
+		return bufio.NewReaderSize(conn, size)
+	case rd.Size() >= size:
+		return rd
 	}
-	return newWS(conn, false), nil
+	n := rd.Buffered()
+	if n == 0 {
+		return bufio.NewReaderSize(conn, size)
+	}
+	p, _ := rd.Peek(n)
+	buffered := bytes.NewReader(append([]byte(nil), p...))
+	br := bufio.NewReaderSize(io.MultiReader(buffered, conn), size)
+	br.Peek(n) // keep the bytes visible through Buffered
+	return br
 }
 
-// Close closes the connection manually by sending the close code 1000.
-func (ws *WebSocket) Close() error {
-	if ws.cc == 0 {
-		ws.cc = 1000
+// newWriter returns a writer for conn, reusing wr if it's at least as large as size.
+// The writer must have been flushed.
+func newWriter(conn net.Conn, wr *bufio.Writer, size int) *bufio.Writer {
+	if size = bufferSize(size); wr != nil && wr.Size() >= size {
+		return wr
 	}
-	ws.SetOpcode(opcodeClose)
-	binary.Write(ws, binary.BigEndian, ws.cc)
+	return bufio.NewWriterSize(conn, size)
+}
 
-	var err error
-	if ws.state >= stateClosing {
-		err = ws.conn.Close()
+// bufferSize returns the size of an I/O buffer, using the default size for zero.
+func bufferSize(size int) int {
+	switch {
+	case size == 0:
+		return defaultRWSize
+	case size < minRWSize:
+		return minRWSize
 	}
-	ws.resolveState()
-	return err
+	return size
+}
+
+// UpgradeHTTP switches the protocol from HTTP to the WebSocket Protocol.
+func UpgradeHTTP(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	var u Upgrader
+	return u.Upgrade(w, r)
+}
+
+// CloseCode returns the close code received from the peer,
+// or the one sent if the connection was closed first.
+func (ws *WebSocket) CloseCode() uint16 {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.cc
+}
+
+func (ws *WebSocket) Err() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.err
 }
 
-func (ws *WebSocket) CloseCode() uint16        { return ws.cc }
-func (ws *WebSocket) Err() error               { return ws.err }
 func (ws *WebSocket) Message() ([]byte, uint8) { return ws.payload, ws.opcode }
 
+// Next reads the next message, which is then available through Message.
+// It returns false when the connection is closed or fails.
+//
+// When a BufferPool is in use, the previous payload is returned to it,
+// so it must not be used after calling Next again.
 func (ws *WebSocket) Next() bool {
-	for {
-		f, err := ws.fb.next()
-		if err != nil {
-			ws.state = stateClosed
-			if err == io.EOF {
-				return false
-			}
-			ws.conn.Close()
-			ws.err = err
-			return false
-		}
+	ws.releaseBuffer()
+	opcode, rd, err := ws.NextReader()
+	if err != nil {
+		return false
+	}
+	buf := ws.getBuffer()
+	if _, err = buf.ReadFrom(rd); err != nil {
+		return false
+	}
+	ws.opcode = opcode
+	ws.payload = buf.Bytes()
+	ws.readPos = 0
+	return true
+}
+
+// NextReader returns the opcode of the next message and a reader for its payload,
+// which is streamed as frames arrive and ends with io.EOF.
+// Control frames received in the meantime are handled transparently.
+//
+// The reader is only valid until NextReader or Next is called again.
+// When the connection is closed, the error is either the one reported by Err or io.EOF.
+// A close frame from the peer is reported as a *CloseError.
+func (ws *WebSocket) NextReader() (uint8, io.Reader, error) {
+	if err := ws.discardMessage(); err != nil {
+		return 0, nil, err
+	}
+	if err := ws.Err(); err != nil {
+		return 0, nil, err
+	}
+	ws.lockRead()
+	h, err := ws.nextHeader()
+	ws.unlockRead()
+	if err != nil {
+		return 0, nil, err
+	}
 
-		switch {
-		case f.opcode == opcodePing:
-			ws.handlePing(f.payload)
-		case f.opcode == opcodePong: // no-op
-		case f.opcode == opcodeClose:
-			defer ws.Close()
-			ws.cc = 1000
-			ws.resolveState()
-			if f.hasCloseCode && !validCloseCode(f.cc) {
-				ws.cc = 1002
-				ws.err = errInvalidCloseCode
-				return false
-			}
-			if !utf8.Valid(f.payload) {
-				ws.cc = 1002
-				ws.err = errInvalidClosePayload
-				return false
-			}
-			ws.opcode = f.opcode
-			ws.payload = f.payload
-			return false
-		default:
-			ws.fb.add(f)
-			if f.final {
-				defer ws.fb.reset()
-				if ws.fb.opcode == OpcodeText && !utf8.Valid(ws.fb.payload) {
-					ws.conn.Close()
-					ws.err = errInvalidUTF8
-					return false
-				}
-				ws.opcode = ws.fb.opcode
-				ws.payload = ws.fb.payload
-				return true
-			}
+	// The readers are reused for every message so that reading doesn't allocate.
+	var rd io.Reader = &ws.fr
+	if h.compressed {
+		if rd, err = ws.fb.inflater.reader(rd); err != nil {
+			return 0, nil, ws.readError(err)
 		}
 	}
+	if h.opcode == OpcodeText {
+		ws.ur = utf8Reader{rd: rd}
+		rd = &ws.ur
+	}
+	ws.mr = messageReader{ws: ws, rd: rd, compressed: h.compressed}
+	ws.rd = &ws.mr
+	return h.opcode, ws.rd, nil
+}
+
+// discardMessage discards whatever was left unread from the current message.
+// Frames are skipped up to the final one even if the payload reader ended earlier,
+// e.g. because a compressed message ended with a final deflate block.
+func (ws *WebSocket) discardMessage() error {
+	if ws.rd == nil {
+		return nil
+	}
+	_, err := io.Copy(io.Discard, ws.rd)
+	if err == nil {
+		_, err = io.Copy(io.Discard, &ws.fr)
+	}
+	ws.rd = nil
+	return err
+}
+
+// NextWriter returns a writer for a message with the given opcode,
+// which must be either OpcodeText or OpcodeBinary.
+// Fragments are sent as the writer's buffer fills up and the final one is sent on Close.
+//
+// Other messages wait until the writer is closed, though control frames
+// such as pongs may still be sent between fragments.
+func (ws *WebSocket) NextWriter(opcode uint8) (io.WriteCloser, error) {
+	return ws.writer.nextWriter(opcode)
 }
 
-func (ws *WebSocket) Read(b []byte) (int, error) { return copy(b, ws.payload), nil }
+// LocalAddr returns the local network address.
+func (ws *WebSocket) LocalAddr() net.Addr { return ws.conn.LocalAddr() }
+
+// RemoteAddr returns the remote network address.
+func (ws *WebSocket) RemoteAddr() net.Addr { return ws.conn.RemoteAddr() }
+
+// Subprotocol returns the subprotocol negotiated during the handshake, if any.
+func (ws *WebSocket) Subprotocol() string { return ws.subprotocol }
+
+// Header returns the header of the opening handshake's response,
+// such as the extensions and subprotocol negotiated with the peer.
+// It must not be modified.
+func (ws *WebSocket) Header() http.Header { return ws.header }
+
+// Read reads the payload of the message read by Next, returning io.EOF at its end.
+func (ws *WebSocket) Read(b []byte) (int, error) {
+	if ws.readPos >= len(ws.payload) {
+		return 0, io.EOF
+	}
+	n := copy(b, ws.payload[ws.readPos:])
+	ws.readPos += n
+	return n, nil
+}
 
 func (ws *WebSocket) SetCloseCode(cc uint16) error {
 	if !validCloseCode(cc) {
-		return errInvalidCloseCode
+		return ErrInvalidCloseCode
 	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
 	ws.cc = cc
 	return nil
 }
 
-func (ws *WebSocket) SetOpcode(opcode uint8) { ws.writer.opcode = opcode }
+// SetDeadline sets the read and write deadlines of the underlying connection.
+// A timeout fails the WebSocket with an error whose Timeout method returns true.
+func (ws *WebSocket) SetDeadline(t time.Time) error { return ws.conn.SetDeadline(t) }
+
+// SetReadDeadline sets the deadline for reading from the underlying connection.
+func (ws *WebSocket) SetReadDeadline(t time.Time) error { return ws.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the deadline for writing to the underlying connection.
+func (ws *WebSocket) SetWriteDeadline(t time.Time) error { return ws.conn.SetWriteDeadline(t) }
 
-func (ws *WebSocket) handlePing(b []byte) {
-	ws.SetOpcode(opcodePong)
-	ws.Write(b)
+// SetReadLimits sets the limits enforced on incoming frames and messages.
+func (ws *WebSocket) SetReadLimits(limits ReadLimits) { ws.fb.limits = limits }
+
+// SetOpcode sets the opcode used by Write.
+//
+// When many goroutines write to the same WebSocket, use WriteMessage instead,
+// since the opcode is shared by all of them.
+func (ws *WebSocket) SetOpcode(opcode uint8) { ws.writer.setOpcode(opcode) }
+
+// WriteMessage sends b as a single message with the given opcode,
+// which must be either OpcodeText or OpcodeBinary.
+//
+// It's safe to call WriteMessage from many goroutines at once.
+func (ws *WebSocket) WriteMessage(opcode uint8, b []byte) error {
+	_, err := ws.writer.writeMessage(opcode, b)
+	return err
+}
+
+// enableCompression sets up permessage-deflate according to the negotiated parameters.
+func (ws *WebSocket) enableCompression(dp *deflateParams, opts *CompressionOptions) {
+	ownNoContext, peerNoContext := dp.serverNoContextTakeover, dp.clientNoContextTakeover
+	windowBits := minBits(dp.serverMaxWindowBits, opts.ServerMaxWindowBits)
+	if ws.writer.client {
+		ownNoContext, peerNoContext = peerNoContext, ownNoContext
+		windowBits = dp.clientMaxWindowBits
+	}
+	ws.writer.deflater = newDeflater(opts.Level, windowBits, !ownNoContext)
+	ws.fb.inflater = &inflater{takeover: !peerNoContext}
+}
+
+// fail sends a close frame with cc and drops the connection.
+func (ws *WebSocket) fail(cc uint16, err error) {
+	ws.mu.Lock()
+	if ws.state == stateClosed {
+		ws.mu.Unlock()
+		return
+	}
+	open := ws.state == stateOpen
+	ws.cc = cc
+	ws.err = err
+	ws.setClosed()
+	ws.mu.Unlock()
+
+	if open {
+		ws.writeClose(cc, "")
+	}
+	ws.conn.Close()
 }
 
-func (ws *WebSocket) resolveState() {
-	switch ws.state {
-	case stateOpen:
-		ws.state = stateClosing
-	case stateClosing:
-		ws.state = stateClosed
+// nextHeader reads frames until a data frame header arrives,
+// handling control frames on the way.
+// The caller must hold the read lock.
+func (ws *WebSocket) nextHeader() (header, error) {
+	ws.mu.Lock()
+	closed := ws.state == stateClosed
+	ws.mu.Unlock()
+	if closed {
+		return header{}, io.EOF
+	}
+	for {
+		h, err := ws.fb.readHeader()
+		if err != nil {
+			return h, ws.readError(err)
+		}
+		if h.opcode < opcodeClose {
+			return h, nil
+		}
+		if err = ws.handleControl(h); err != nil {
+			return h, err
+		}
+	}
+}
+
+// handleControl reads and handles a control frame whose header was just read.
+func (ws *WebSocket) handleControl(h header) error {
+	f, err := ws.fb.readFrame(h)
+	if err != nil {
+		return ws.readError(err)
+	}
+	switch f.opcode {
+	case opcodePing:
+		err = ws.handlePing(f.payload)
+	case opcodePong:
+		err = ws.handlePong(f.payload)
+	case opcodeClose:
+		return ws.handleClose(f)
+	}
+	if err != nil {
+		return ws.readError(err)
+	}
+	return nil
+}
+
+// readError drops the connection after a read fails.
+// Protocol errors are sent to the peer as a close frame first and returned as a *ProtocolError.
+// Errors after the connection is closed are not reported by Err.
+func (ws *WebSocket) readError(err error) error {
+	ws.mu.Lock()
+	closed := ws.state == stateClosed
+	ws.mu.Unlock()
+	if closed {
+		return err
+	}
+	if cc := protocolCloseCode(err); cc != 0 {
+		err = &ProtocolError{Code: cc, Err: err}
+		ws.fail(cc, err)
+		return err
+	}
+
+	ws.mu.Lock()
+	ws.setClosed()
+	ws.mu.Unlock()
+	ws.conn.Close()
+	if err == io.EOF {
+		// The connection was dropped without a close frame.
+		ws.setErr(&CloseError{Code: 1006})
+	} else {
+		ws.setErr(err)
+	}
+	return err
+}
+
+// drop closes the connection without a closing handshake.
+func (ws *WebSocket) drop() {
+	ws.mu.Lock()
+	ws.setClosed()
+	ws.mu.Unlock()
+	ws.conn.Close()
+}
+
+// writeError drops the connection after a write fails,
+// since the peer may have received an incomplete frame.
+func (ws *WebSocket) writeError(err error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.state == stateClosed {
+		return
+	}
+	ws.setClosed()
+	if ws.err == nil {
+		ws.err = err
+	}
+	ws.conn.Close()
+}
+
+func (ws *WebSocket) setErr(err error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.err == nil {
+		ws.err = err
+	}
+}
+
+// setClosed marks the connection as closed, stopping keepalive pings.
+// The caller must hold ws.mu.
+func (ws *WebSocket) setClosed() {
+	if ws.state == stateClosed {
+		return
+	}
+	ws.state = stateClosed
+	close(ws.done)
+	for _, fn := range ws.onClosed {
+		fn()
+	}
+	if ws.pongTimer != nil {
+		ws.pongTimer.Stop()
+		ws.pongTimer = nil
 	}
 }
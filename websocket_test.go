@@ -0,0 +1,334 @@
+package websocket_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gbrlsnchs/websocket"
+)
+
+func TestReadLimits(t *testing.T) {
+	testCases := []struct {
+		limits   ReadLimits
+		compress bool
+		size     int
+		err      error
+	}{
+		{limits: ReadLimits{}, size: 1024, err: nil},
+		{limits: ReadLimits{FrameSize: 512}, size: 1024, err: ErrFrameTooBig},
+		{limits: ReadLimits{MessageSize: 512}, size: 1024, err: ErrMessageTooBig},
+		{limits: ReadLimits{MessageSize: 512}, size: 512, err: nil},
+		{limits: ReadLimits{MessageSize: 512}, compress: true, size: 1024, err: ErrMessageTooBig},
+		// Messages larger than the write buffer are sent in fragments.
+		{limits: ReadLimits{MessageSize: 8192}, size: 8192, err: nil},
+		{limits: ReadLimits{Fragments: 1}, size: 8192, err: ErrTooManyFragments},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			errc := make(chan error, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				u := Upgrader{ReadLimits: tc.limits, Compression: &CompressionOptions{}}
+				ws, err := u.Upgrade(w, r)
+				if err != nil {
+					errc <- err
+					return
+				}
+				ws.Next()
+				errc <- ws.Err()
+				if ws.Err() != nil {
+					if want, got := uint16(1009), ws.CloseCode(); want != got {
+						t.Errorf("want %d, got %d", want, got)
+					}
+				}
+				ws.Close()
+			}))
+			defer srv.Close()
+
+			d := Dialer{Timeout: time.Second}
+			if tc.compress {
+				d.Compression = &CompressionOptions{}
+			}
+			ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			defer ws.Close()
+
+			ws.Write(bytes.Repeat([]byte{'a'}, tc.size))
+			if want, got := true, errors.Is(<-errc, tc.err); want != got {
+				t.Errorf("want %t, got %t", want, got)
+			}
+			if tc.err == nil {
+				return
+			}
+			// The server sends close code 1009 before dropping the connection.
+			ws.Next()
+			var cerr *CloseError
+			if want, got := true, errors.As(ws.Err(), &cerr) && cerr.Code == 1009; want != got {
+				t.Errorf("want %t, got %t (%v)", want, got, ws.Err())
+			}
+		})
+	}
+}
+
+func TestNextReader(t *testing.T) {
+	testCases := []struct {
+		compress bool
+		opcode   uint8
+		size     int
+	}{
+		{opcode: OpcodeText, size: 0},
+		{opcode: OpcodeText, size: 100},
+		{opcode: OpcodeBinary, size: 10000},
+		{compress: true, opcode: OpcodeText, size: 10000},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				u := Upgrader{Compression: &CompressionOptions{}}
+				ws, err := u.Upgrade(w, r)
+				if err != nil {
+					return
+				}
+				defer ws.Close()
+				for {
+					opcode, rd, err := ws.NextReader()
+					if err != nil {
+						return
+					}
+					var (
+						payload []byte
+						b       = make([]byte, 7)
+					)
+					for {
+						n, err := rd.Read(b)
+						payload = append(payload, b[:n]...)
+						if err != nil {
+							break
+						}
+					}
+					ws.SetOpcode(opcode)
+					ws.Write(payload)
+				}
+			}))
+			defer srv.Close()
+
+			d := Dialer{Timeout: time.Second}
+			if tc.compress {
+				d.Compression = &CompressionOptions{}
+			}
+			ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			defer ws.Close()
+
+			msg := bytes.Repeat([]byte("ação"), tc.size/len("ação"))
+			ws.SetOpcode(tc.opcode)
+			ws.Write(append([]byte(nil), msg...))
+			opcode, rd, err := ws.NextReader()
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			if want, got := tc.opcode, opcode; want != got {
+				t.Errorf("want %#x, got %#x", want, got)
+			}
+			var buf bytes.Buffer
+			if _, err = buf.ReadFrom(rd); err != nil {
+				t.Fatal(err)
+			}
+			if want, got := string(msg), buf.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestNextWriter(t *testing.T) {
+	testCases := []struct {
+		compress bool
+		opcode   uint8
+		writes   int
+	}{
+		{opcode: OpcodeText, writes: 0},
+		{opcode: OpcodeText, writes: 1},
+		{opcode: OpcodeBinary, writes: 1000},
+		{compress: true, opcode: OpcodeText, writes: 1000},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				u := Upgrader{Compression: &CompressionOptions{}}
+				ws, err := u.Upgrade(w, r)
+				if err != nil {
+					return
+				}
+				defer ws.Close()
+				for ws.Next() {
+					payload, opcode := ws.Message()
+					wc, err := ws.NextWriter(opcode)
+					if err != nil {
+						return
+					}
+					wc.Write(payload)
+					wc.Close()
+				}
+			}))
+			defer srv.Close()
+
+			d := Dialer{Timeout: time.Second}
+			if tc.compress {
+				d.Compression = &CompressionOptions{}
+			}
+			ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			defer ws.Close()
+
+			wc, err := ws.NextWriter(tc.opcode)
+			if want, got := (error)(nil), err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			var msg []byte
+			for i := 0; i < tc.writes; i++ {
+				b := []byte(fmt.Sprintf("write #%d;", i))
+				msg = append(msg, b...)
+				wc.Write(b)
+			}
+			if err = wc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if want, got := true, ws.Next(); want != got {
+				t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+			}
+			payload, opcode := ws.Message()
+			if want, got := tc.opcode, opcode; want != got {
+				t.Errorf("want %#x, got %#x", want, got)
+			}
+			if want, got := string(msg), string(payload); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	const (
+		writers  = 8
+		messages = 50
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := Upgrader{Compression: &CompressionOptions{}}
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for ws.Next() {
+			payload, opcode := ws.Message()
+			ws.WriteMessage(opcode, payload)
+		}
+	}))
+	defer srv.Close()
+
+	d := Dialer{Timeout: time.Second, Compression: &CompressionOptions{}}
+	ws, err := d.Dial(strings.Replace(srv.URL, "http", "ws", 1))
+	if want, got := (error)(nil), err; want != got {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	defer ws.Close()
+
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			for j := 0; j < messages; j++ {
+				// Large messages are fragmented, which must not interleave either.
+				msg := bytes.Repeat([]byte{byte('a' + i)}, 1000*(j%10))
+				if j%2 == 0 {
+					ws.WriteMessage(OpcodeBinary, msg)
+					continue
+				}
+				wc, err := ws.NextWriter(OpcodeBinary)
+				if err != nil {
+					return
+				}
+				wc.Write(msg[:len(msg)/2])
+				wc.Write(msg[len(msg)/2:])
+				wc.Close()
+			}
+		}(i)
+	}
+	for i := 0; i < writers*messages; i++ {
+		if want, got := true, ws.Next(); want != got {
+			t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+		}
+		payload, _ := ws.Message()
+		if len(payload) > 0 && bytes.Count(payload, payload[:1]) != len(payload) {
+			t.Fatalf("message %d was interleaved with another", i)
+		}
+	}
+}
+
+func TestDeadlines(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := UpgradeHTTP(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for ws.Next() { // never writes anything back
+		}
+	}))
+	defer srv.Close()
+
+	t.Run("read", func(t *testing.T) {
+		ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+		if want, got := (error)(nil), err; want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		defer ws.Close()
+
+		ws.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if want, got := false, ws.Next(); want != got {
+			t.Fatalf("want %t, got %t", want, got)
+		}
+		nerr, ok := ws.Err().(net.Error)
+		if want, got := true, ok && nerr.Timeout(); want != got {
+			t.Fatalf("want %t, got %t (%v)", want, got, ws.Err())
+		}
+		if want, got := false, ws.Next(); want != got {
+			t.Errorf("want %t, got %t", want, got)
+		}
+		// The connection is dropped after a timeout.
+		if want, got := true, ws.WriteMessage(OpcodeText, []byte("hello")) != nil; want != got {
+			t.Errorf("want %t, got %t", want, got)
+		}
+	})
+	t.Run("write", func(t *testing.T) {
+		ws, err := Open(strings.Replace(srv.URL, "http", "ws", 1), time.Second)
+		if want, got := (error)(nil), err; want != got {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+		defer ws.Close()
+
+		ws.SetWriteDeadline(time.Now().Add(-time.Second))
+		err = ws.WriteMessage(OpcodeText, []byte("hello"))
+		nerr, ok := err.(net.Error)
+		if want, got := true, ok && nerr.Timeout(); want != got {
+			t.Fatalf("want %t, got %t (%v)", want, got, err)
+		}
+		if want, got := err, ws.Err(); want != got {
+			t.Errorf("want %v, got %v", want, got)
+		}
+		if want, got := false, ws.Next(); want != got {
+			t.Errorf("want %t, got %t", want, got)
+		}
+	})
+}
@@ -2,102 +2,305 @@ package websocket
 
 import (
 	"bufio"
-	"crypto/rand"
+	"bytes"
 	"encoding/binary"
-	"io"
+	"errors"
 	"math"
+	"sync"
 )
 
+// maxHeaderSize is the size of a header with a 64-bit length and a masking key.
+const maxHeaderSize = 14
+
+var errWriterClosed = errors.New("websocket: write to closed message writer")
+
+// messageBuffers holds the fragment buffers of messages being written,
+// so that idle connections don't hold any.
+var messageBuffers sync.Pool
+
+// writer sends frames through a buffered writer.
+// It's safe for concurrent use: frames never interleave on the wire,
+// and neither do the fragments of different messages.
 type writer struct {
-	wr     *bufio.Writer
-	opcode uint8
-	err    error
-	client bool
+	mu       sync.Mutex // guards frames
+	msgMu    sync.Mutex // guards messages, held from nextWriter until the message is closed
+	wr       *bufio.Writer
+	opcode   uint8
+	err      error
+	client   bool
+	deflater *deflater
+	buf      []byte  // payload of the next fragment, only held while a message is written
+	bufp     *[]byte // the pooled buffer behind buf
+	onError  func(error)
+
+	// Scratch space guarded by mu, so that writing frames doesn't allocate.
+	hdr     [maxHeaderSize]byte
+	control [125]byte
+	keys    maskKeys
 }
 
+// Write sends b as a single message using the current opcode.
+// Messages larger than the write buffer are sent in fragments.
 func (w *writer) Write(b []byte) (int, error) {
-	if w.err != nil {
-		return 0, w.err
+	w.mu.Lock()
+	opcode := w.opcode
+	w.mu.Unlock()
+	if opcode >= opcodeClose {
+		if err := w.writeControl(opcode, b); err != nil {
+			return 0, err
+		}
+		return len(b), nil
 	}
-	wr := w.wr
+	return w.writeMessage(opcode, b)
+}
 
-	fin := leftBit | w.opcode
-	// Check if message needs to be fragmented.
-	if bsize, diff := w.byteSize(b), wr.Available(); bsize > diff {
-		diff -= bsize - len(b)                                // resolve payload length
-		fin &= w.opcode                                       // set FIN bit to zero
-		next := &writer{wr: w.wr, opcode: opcodeContinuation} // prepare next frame to be sent
-		defer next.Write(b[diff:])                            // schedule next write
-		b = b[:diff]                                          // resolve current payload
+// writeMessage sends b as a single data message.
+func (w *writer) writeMessage(opcode uint8, b []byte) (int, error) {
+	mw, err := w.nextWriter(opcode)
+	if err != nil {
+		return 0, err
 	}
+	n, err := mw.Write(b)
+	if cerr := mw.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
 
-	if w.err = wr.WriteByte(fin); w.err != nil {
-		return 0, w.err
+func (w *writer) setOpcode(opcode uint8) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.opcode = opcode
+}
+
+// nextWriter starts a new message, waiting for the previous one to be closed.
+func (w *writer) nextWriter(opcode uint8) (*messageWriter, error) {
+	if opcode != OpcodeText && opcode != OpcodeBinary {
+		return nil, ErrInvalidOpcode
+	}
+	w.msgMu.Lock()
+	w.mu.Lock()
+	err := w.err
+	w.mu.Unlock()
+	if err != nil {
+		w.msgMu.Unlock()
+		return nil, err
+	}
+	mw := &messageWriter{w: w, opcode: opcode}
+	// Compress data messages if permessage-deflate was negotiated.
+	if w.deflater != nil {
+		if err = w.deflater.reset(); err != nil {
+			w.msgMu.Unlock()
+			return nil, err
+		}
+		mw.rsv = rsv1Bit
+		mw.compressed = true
 	}
+	w.getMessageBuffer()
+	return mw, nil
+}
 
-	size := len(b)
-	var maskedBit uint8
+// getMessageBuffer takes a fragment buffer from the pool.
+// The caller must hold msgMu.
+func (w *writer) getMessageBuffer() {
+	size := w.wr.Size() - maxHeaderSize
+	p, _ := messageBuffers.Get().(*[]byte)
+	if p == nil || cap(*p) < size {
+		b := make([]byte, 0, size)
+		p = &b
+	}
+	w.bufp = p
+	w.buf = (*p)[:0:size]
+}
+
+// putMessageBuffer returns the fragment buffer to the pool once a message is sent.
+// The caller must hold msgMu.
+func (w *writer) putMessageBuffer() {
+	if w.bufp == nil {
+		return
+	}
+	messageBuffers.Put(w.bufp)
+	w.bufp = nil
+	w.buf = nil
+}
+
+// writeControl sends a control frame and flushes it right away.
+func (w *writer) writeControl(opcode uint8, b []byte) error {
+	if len(b) > 125 {
+		return ErrLargeControlFrame
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// Copy the payload so that masking doesn't modify the caller's slice.
+	n := copy(w.control[:], b)
+	if err := w.writeFrame(leftBit|opcode, w.control[:n]); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// writeFrame writes a frame to the buffered writer.
+// In client mode, the payload is masked in place,
+// so it must never be a slice passed in by the caller.
+// The caller must hold w.mu.
+func (w *writer) writeFrame(b0 byte, payload []byte) error {
+	m, err := w.writeHeader(b0, len(payload))
+	if err != nil {
+		return err
+	}
+	if w.client {
+		m.transform(payload, 0)
+	}
+	_, err = w.wr.Write(payload)
+	return w.setErr(err)
+}
+
+// writeHeader writes the header of a frame, returning its masking key in client mode.
+// The caller must hold w.mu.
+func (w *writer) writeHeader(b0 byte, size int) (mask, error) {
+	var m mask
+	if w.err != nil {
+		return m, w.err
+	}
+	n := encodeHeader(w.hdr[:], b0, size, w.client)
 	if w.client {
+		var err error
+		if m, err = w.keys.next(); err != nil {
+			return m, w.setErr(err)
+		}
+		n += copy(w.hdr[n:], m[:])
+	}
+	_, err := w.wr.Write(w.hdr[:n])
+	return m, w.setErr(err)
+}
+
+// encodeHeader encodes a frame header without its masking key into hdr,
+// returning its length.
+func encodeHeader(hdr []byte, b0 byte, size int, masked bool) int {
+	var maskedBit uint8
+	if masked {
 		maskedBit = leftBit
 	}
+	hdr[0] = b0
 	switch {
 	case size <= 125:
-		if w.err = wr.WriteByte(uint8(size) | maskedBit); w.err != nil {
-			return 0, w.err
-		}
+		hdr[1] = uint8(size) | maskedBit
+		return 2
 	case size <= math.MaxUint16:
-		if w.err = wr.WriteByte(126 | maskedBit); w.err != nil {
-			return 0, w.err
-		}
-		if w.err = binary.Write(wr, binary.BigEndian, uint16(size)); w.err != nil {
-			return 0, w.err
-		}
+		hdr[1] = 126 | maskedBit
+		binary.BigEndian.PutUint16(hdr[2:], uint16(size))
+		return 4
 	default:
-		if w.err = wr.WriteByte(127 | maskedBit); w.err != nil {
-			return 0, w.err
+		hdr[1] = 127 | maskedBit
+		binary.BigEndian.PutUint64(hdr[2:], uint64(size))
+		return 10
+	}
+}
+
+func (w *writer) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.setErr(w.wr.Flush())
+}
+
+// setErr makes err sticky, reporting it the first time it happens.
+// The caller must hold w.mu.
+func (w *writer) setErr(err error) error {
+	if err != nil && w.err == nil {
+		w.err = err
+		if w.onError != nil {
+			w.onError(err)
 		}
-		if w.err = binary.Write(wr, binary.BigEndian, uint64(size)); w.err != nil {
-			return 0, w.err
+	}
+	return err
+}
+
+// messageWriter sends a message in fragments as its buffer fills up.
+// The final fragment is only sent when it's closed, which lets other messages be written.
+type messageWriter struct {
+	w          *writer
+	opcode     uint8
+	rsv        byte
+	compressed bool
+	closed     bool
+}
+
+func (mw *messageWriter) Write(b []byte) (int, error) {
+	if mw.closed {
+		return 0, errWriterClosed
+	}
+	if !mw.compressed {
+		return mw.write(b)
+	}
+	d := mw.w.deflater
+	if _, err := d.fw.Write(b); err != nil {
+		return 0, err
+	}
+	// Hold back the last bytes, since they may be the flush marker removed on Close.
+	if p := d.buf.Bytes(); len(p) > len(flushMarker) {
+		keep := len(p) - len(flushMarker)
+		if _, err := mw.write(p[:keep]); err != nil {
+			return 0, err
 		}
+		d.buf.Reset()
+		d.buf.Write(p[keep:])
 	}
+	return len(b), nil
+}
 
-	// Mask the payload.
-	if w.client {
-		m := make(mask, 4)
-		if _, w.err = io.ReadFull(rand.Reader, m); w.err != nil {
-			return 0, w.err
+// Close sends the final fragment of the message.
+func (mw *messageWriter) Close() error {
+	if mw.closed {
+		return nil
+	}
+	mw.closed = true
+	defer mw.w.msgMu.Unlock()
+	defer mw.w.putMessageBuffer()
+	if mw.compressed {
+		d := mw.w.deflater
+		if err := d.fw.Flush(); err != nil {
+			return err
 		}
-		if _, w.err = wr.Write(m); w.err != nil {
-			return 0, w.err
+		// Remove the empty block that ends the flush.
+		if _, err := mw.write(bytes.TrimSuffix(d.buf.Bytes(), flushMarker)); err != nil {
+			return err
 		}
-		m.transform(b)
 	}
+	return mw.flushFrame(true)
+}
 
-	// Write message and flush.
-	if _, w.err = wr.Write(b); w.err != nil {
-		return 0, w.err
-	}
-	n := wr.Buffered()
-	if w.err = wr.Flush(); w.err != nil {
-		return 0, w.err
+// write buffers raw payload, sending a fragment whenever the buffer is full.
+func (mw *messageWriter) write(b []byte) (int, error) {
+	w, n := mw.w, 0
+	for len(b) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			if err := mw.flushFrame(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):cap(w.buf)], b)
+		w.buf = w.buf[:len(w.buf)+m]
+		b = b[m:]
+		n += m
 	}
 	return n, nil
 }
 
-func (w *writer) byteSize(b []byte) (size int) {
-	size++ // FIN
-
-	length := len(b)
-	switch {
-	case length <= 125:
-		size++ // indicator is the current length
-	case length <= math.MaxUint16:
-		size += 3 // indicator + 2 bytes for length value
-	default:
-		size += 9 // indicator + 8 bytes for length value
+func (mw *messageWriter) flushFrame(final bool) error {
+	w := mw.w
+	b0 := mw.opcode | mw.rsv
+	if final {
+		b0 |= leftBit
 	}
-	if w.client {
-		size += 4 // 4 bytes for masking-key
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.writeFrame(b0, w.buf)
+	w.buf = w.buf[:0]
+	mw.opcode = opcodeContinuation
+	mw.rsv = 0
+	if err != nil {
+		return err
 	}
-	return size + length
+	return w.flush()
 }
@@ -0,0 +1,22 @@
+package websocket
+
+import "testing"
+
+func TestWriterReleasesBuffer(t *testing.T) {
+	ws := newTestWS(&captureConn{}, false)
+	mw, err := ws.NextWriter(OpcodeBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw.Write([]byte("hello"))
+	if want, got := true, ws.writer.bufp != nil; want != got {
+		t.Errorf("want %t, got %t", want, got)
+	}
+	if err = mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Idle connections don't hold a fragment buffer.
+	if want, got := true, ws.writer.bufp == nil && ws.writer.buf == nil; want != got {
+		t.Errorf("want %t, got %t", want, got)
+	}
+}